@@ -0,0 +1,263 @@
+// Command mockoidc is a minimal OpenID Provider for trying the OpenID Connect login locally.
+// It signs in whoever fills in its form, so it must never be exposed.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"github.com/golang-jwt/jwt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyID            = "mock"
+	codeDuration     = time.Minute
+	idTokenDuration  = 5 * time.Minute
+	defaultEmailHint = "student@example.com"
+)
+
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	subject       string
+	email         string
+	emailVerified bool
+	name          string
+	expiresAt     time.Time
+}
+
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer, the URL the provider is reached at")
+	clientID := flag.String("client-id", "planny", "client id of the relying party")
+	clientSecret := flag.String("client-secret", "", "client secret of the relying party, not checked when empty")
+	flag.Parse()
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot generate key")
+	}
+	p := &provider{
+		issuer:       strings.TrimSuffix(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		codes:        map[string]authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	log.Info().Str("addr", *addr).Str("issuer", p.issuer).Msg("mock oidc provider started")
+	if err = http.ListenAndServe(*addr, mux); err != nil {
+		log.Fatal().Err(err).Msg("cannot start server")
+	}
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Mock OpenID Provider</title>
+<h1>Mock OpenID Provider</h1>
+<form method="post">
+{{range $name, $value := .Query}}<input type="hidden" name="{{$name}}" value="{{index $value 0}}">
+{{end}}<p><label>Email <input name="email" value="{{.Email}}"></label></p>
+<p><label>Name <input name="name" value="Mock Student"></label></p>
+<p><label><input type="checkbox" name="email_verified" value="true" checked> Email verified</label></p>
+<p><button name="action" value="approve">Sign in</button> <button name="action" value="deny">Deny</button></p>
+</form>`))
+
+// authorize shows a form on GET and answers the relying party on POST, the parameters of the
+// request travel through the form
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.Form
+	redirectURI := query.Get("redirect_uri")
+	switch {
+	case query.Get("client_id") != p.clientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case redirectURI == "":
+		http.Error(w, "redirect_uri is required", http.StatusBadRequest)
+		return
+	}
+	callback, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	answer := func(values url.Values) {
+		values.Set("state", query.Get("state"))
+		callback.RawQuery = values.Encode()
+		http.Redirect(w, r, callback.String(), http.StatusFound)
+	}
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		answer(url.Values{"error": {"invalid_request"}})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		email := query.Get("login_hint")
+		if email == "" {
+			email = defaultEmailHint
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = loginPage.Execute(w, map[string]interface{}{"Query": r.URL.Query(), "Email": email})
+		return
+	}
+	if query.Get("action") != "approve" {
+		answer(url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	code := randomString()
+	email := strings.ToLower(strings.TrimSpace(query.Get("email")))
+	subject := sha256.Sum256([]byte(email))
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:      p.clientID,
+		redirectURI:   redirectURI,
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		subject:       base64.RawURLEncoding.EncodeToString(subject[:16]),
+		email:         email,
+		emailVerified: query.Get("email_verified") == "true",
+		name:          query.Get("name"),
+		expiresAt:     time.Now().Add(codeDuration),
+	}
+	p.mu.Unlock()
+	answer(url.Values{"code": {code}})
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+	form := r.PostForm
+	p.mu.Lock()
+	auth, ok := p.codes[form.Get("code")]
+	delete(p.codes, form.Get("code"))
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(form.Get("code_verifier")))
+	switch {
+	case form.Get("grant_type") != "authorization_code":
+		tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+	case !ok || time.Now().After(auth.expiresAt):
+		tokenError(w, "invalid_grant", "unknown or expired code")
+	case form.Get("client_id") != auth.clientID || (p.clientSecret != "" && form.Get("client_secret") != p.clientSecret):
+		tokenError(w, "invalid_client", "client authentication failed")
+	case form.Get("redirect_uri") != auth.redirectURI:
+		tokenError(w, "invalid_grant", "redirect_uri does not match")
+	case base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.codeChallenge:
+		tokenError(w, "invalid_grant", "code_verifier does not match")
+	default:
+		p.issueTokens(w, auth)
+	}
+}
+
+func (p *provider) issueTokens(w http.ResponseWriter, auth authorization) {
+	now := time.Now()
+	first, last, _ := strings.Cut(auth.name, " ")
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.issuer,
+		"sub":            auth.subject,
+		"aud":            auth.clientID,
+		"exp":            now.Add(idTokenDuration).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          auth.nonce,
+		"email":          auth.email,
+		"email_verified": auth.emailVerified,
+		"name":           auth.name,
+		"given_name":     first,
+		"family_name":    last,
+	})
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		tokenError(w, "server_error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenDuration.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
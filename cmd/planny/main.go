@@ -3,12 +3,16 @@
 import (
 	"com.github/asdsec/planny/configs"
 	"com.github/asdsec/planny/internal/api"
+	"com.github/asdsec/planny/internal/security"
 	"com.github/asdsec/planny/internal/store"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"os"
+	"time"
 )
 
 func main() {
@@ -20,18 +24,37 @@ func main() {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	}
 
-	conn, err := gorm.Open(mysql.Open(conf.DatabaseURL), &gorm.Config{})
+	conn, err := gorm.Open(mysql.Open(conf.DatabaseURL), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot connect to db")
 	}
 	err = conn.AutoMigrate(
 		&db.UserEntity{},
 		&db.SessionEntity{},
+		&db.RefreshTokenEntity{},
+		&db.OneTimeTokenEntity{},
+		&db.RecoveryCodeEntity{},
+		&db.PersonalAccessTokenEntity{},
+		&db.UserIdentityEntity{},
+		&db.LoginAttemptEntity{},
+		&db.LoginLockoutEntity{},
 		&db.PlanEntity{},
+		&db.PlanItemEntity{},
+		&db.TagEntity{},
+		&db.CalendarFeedEntity{},
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot migrate db")
 	}
+	// sessions used to keep their refresh token in plaintext
+	if conn.Migrator().HasColumn(&db.SessionEntity{}, "refresh_token") {
+		if err = migrateSessionRefreshTokens(conn); err != nil {
+			log.Fatal().Err(err).Msg("cannot migrate db")
+		}
+		if err = conn.Migrator().DropColumn(&db.SessionEntity{}, "refresh_token"); err != nil {
+			log.Fatal().Err(err).Msg("cannot migrate db")
+		}
+	}
 	store := db.NewStore(conn)
 
 	serv, err := api.NewServer(conf, store)
@@ -44,3 +67,39 @@ func main() {
 		log.Fatal().Err(err).Msg("cannot start server")
 	}
 }
+
+// migrateSessionRefreshTokens stores the hash of the plaintext refresh token of every live session
+// so that nobody is logged out when the column is dropped. Tokens already migrated are skipped,
+// which lets a migration interrupted before the drop run again.
+func migrateSessionRefreshTokens(conn *gorm.DB) error {
+	var sessions []struct {
+		ID           uuid.UUID
+		RefreshToken string
+		ExpiresAt    time.Time
+	}
+	err := conn.Model(&db.SessionEntity{}).
+		Select("id", "refresh_token", "expires_at").
+		Where("refresh_token <> '' AND is_blocked = ? AND expires_at > ?", false, time.Now()).
+		Find(&sessions).Error
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	tokens := make([]db.RefreshTokenEntity, 0, len(sessions))
+	for _, session := range sessions {
+		tokens = append(tokens, db.RefreshTokenEntity{
+			TokenHash: security.HashToken(session.RefreshToken),
+			SessionID: session.ID,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
+	err = conn.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(tokens, 500).Error
+	if err != nil {
+		return err
+	}
+	log.Info().Int("sessions", len(tokens)).Msg("migrated refresh tokens of sessions")
+	return nil
+}
@@ -15,6 +15,51 @@ type Config struct {
 	TokenSymmetricKey    string        `mapstructure:"TOKEN_SYMMETRIC_KEY"`
 	AccessTokenDuration  time.Duration `mapstructure:"ACCESS_TOKEN_DURATION"`
 	RefreshTokenDuration time.Duration `mapstructure:"REFRESH_TOKEN_DURATION"`
+	// TokenKeySetFile switches access and refresh tokens from HS256 to the asymmetric keys of
+	// a key set file, retired keys keep verifying for TokenKeyGracePeriod
+	TokenKeySetFile     string        `mapstructure:"TOKEN_KEYSET_FILE"`
+	TokenKeyGracePeriod time.Duration `mapstructure:"TOKEN_KEY_GRACE_PERIOD"`
+	// TokenFormat is the format new tokens are issued in, tokens in one of the comma separated
+	// TokenLegacyFormats are still accepted while clients migrate
+	TokenFormat          string `mapstructure:"TOKEN_FORMAT"`
+	TokenLegacyFormats   string `mapstructure:"TOKEN_LEGACY_FORMATS"`
+	PasetoLocalKey       string `mapstructure:"PASETO_LOCAL_KEY"`
+	PasetoPrivateKeyFile string `mapstructure:"PASETO_PRIVATE_KEY_FILE"`
+	// StrictSessionCheck makes every authorized request verify that the session of its access
+	// token has not been revoked, turned off access tokens outlive a logout until they expire
+	StrictSessionCheck bool `mapstructure:"STRICT_SESSION_CHECK"`
+	// TrustedProxies are the comma separated CIDRs of the proxies whose X-Forwarded-For header
+	// tells the client address, the address of the connection is used when it is empty
+	TrustedProxies string `mapstructure:"TRUSTED_PROXIES"`
+	// AppURL is the base URL of the client, links sent by email point to it
+	AppURL                     string        `mapstructure:"APP_URL"`
+	MailSender                 string        `mapstructure:"MAIL_SENDER"`
+	MailFrom                   string        `mapstructure:"MAIL_FROM"`
+	SMTPAddress                string        `mapstructure:"SMTP_ADDRESS"`
+	SMTPUsername               string        `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword               string        `mapstructure:"SMTP_PASSWORD"`
+	PasswordResetTokenDuration time.Duration `mapstructure:"PASSWORD_RESET_TOKEN_DURATION"`
+	EmailVerificationDuration  time.Duration `mapstructure:"EMAIL_VERIFICATION_DURATION"`
+	MfaChallengeDuration       time.Duration `mapstructure:"MFA_CHALLENGE_DURATION"`
+	// MagicLinkDuration is how long an emailed login link works, an email address is sent at
+	// most MagicLinkMaxRequests links within MagicLinkWindow
+	MagicLinkDuration    time.Duration `mapstructure:"MAGIC_LINK_DURATION"`
+	MagicLinkMaxRequests uint          `mapstructure:"MAGIC_LINK_MAX_REQUESTS"`
+	MagicLinkWindow      time.Duration `mapstructure:"MAGIC_LINK_WINDOW"`
+	// LoginMaxAttempts is the number of failed logins of an account before it is locked out,
+	// LoginMaxIPAttempts the same for a client address. Each further failure doubles the
+	// lockout starting at LoginLockoutDuration up to LoginMaxLockoutDuration.
+	LoginMaxAttempts        uint          `mapstructure:"LOGIN_MAX_ATTEMPTS"`
+	LoginMaxIPAttempts      uint          `mapstructure:"LOGIN_MAX_IP_ATTEMPTS"`
+	LoginLockoutDuration    time.Duration `mapstructure:"LOGIN_LOCKOUT_DURATION"`
+	LoginMaxLockoutDuration time.Duration `mapstructure:"LOGIN_MAX_LOCKOUT_DURATION"`
+	// EmailVerification is what unverified accounts are kept from, one of optional, login
+	// and plans
+	EmailVerification string `mapstructure:"EMAIL_VERIFICATION"`
+	// OidcProvidersFile lists the OpenID Providers students can sign in with, providers send
+	// students back to APIURL, the public base URL of this server
+	OidcProvidersFile string `mapstructure:"OIDC_PROVIDERS_FILE"`
+	APIURL            string `mapstructure:"API_URL"`
 }
 
 // Load loads the configuration from the environment variables
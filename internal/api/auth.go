@@ -5,8 +5,9 @@
 	"com.github/asdsec/planny/internal/security"
 	db "com.github/asdsec/planny/internal/store"
 	"errors"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"gorm.io/gorm"
+	"github.com/rs/zerolog/log"
 	"net/http"
 	"time"
 )
@@ -14,12 +15,15 @@
 func (serv *Server) register(ctx echo.Context) error {
 	var req registerRequest
 	if err := ctx.Bind(&req); err != nil {
-		return serv.err(ctx, http.StatusBadRequest, "invalid request")
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
 	}
 
 	password, err := security.HashPassword(req.Password)
 	if err != nil {
-		return serv.err(ctx, http.StatusInternalServerError, "failed to hash password")
+		return newError(http.StatusInternalServerError, "failed to hash password")
 	}
 
 	arg := db.CreateUserArg{
@@ -31,11 +35,12 @@ func (serv *Server) register(ctx echo.Context) error {
 	}
 	user, err := serv.store.CreateUser(ctx.Request().Context(), arg)
 	if err != nil {
-		if errors.Is(err, gorm.ErrForeignKeyViolated) {
-			return serv.err(ctx, http.StatusBadRequest, "username or email already exists")
+		if errors.Is(err, db.ErrDuplicatedKey) {
+			return newError(http.StatusConflict, "username or email already exists").withCode(codeDuplicated)
 		}
-		return serv.err(ctx, http.StatusInternalServerError, "failed to create user")
+		return newError(http.StatusInternalServerError, "failed to create user")
 	}
+	go serv.sendEmailVerification(user)
 
 	return ctx.JSON(http.StatusOK, newRegisterResponse(&user))
 }
@@ -50,87 +55,126 @@ func (serv *Server) register(ctx echo.Context) error {
 	}
 
 	registerResponse struct {
-		Username    string    `json:"username"`
-		Email       string    `json:"email"`
-		DisplayName string    `json:"display_name"`
-		UpdatedAt   time.Time `json:"updated_at"`
-		CreatedAt   time.Time `json:"created_at"`
+		Username      string    `json:"username"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		DisplayName   string    `json:"display_name"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		CreatedAt     time.Time `json:"created_at"`
 	}
 )
 
 func newRegisterResponse(user *model.User) *registerResponse {
 	return &registerResponse{
-		Username:    user.Username,
-		Email:       user.Email,
-		DisplayName: user.FirstName + " " + user.LastName,
-		UpdatedAt:   user.UpdatedAt,
-		CreatedAt:   user.CreatedAt,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		DisplayName:   user.FirstName + " " + user.LastName,
+		UpdatedAt:     user.UpdatedAt,
+		CreatedAt:     user.CreatedAt,
 	}
 }
 
 func (serv *Server) login(ctx echo.Context) error {
 	var req loginRequest
 	if err := ctx.Bind(&req); err != nil {
-		return serv.err(ctx, http.StatusBadRequest, "invalid request")
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
 	}
 	if req.Username == "" && req.Email == "" {
-		return serv.err(ctx, http.StatusBadRequest, "username or email is required")
+		return newError(http.StatusBadRequest, "username or email is required")
+	}
+
+	identifier := req.Username
+	if identifier == "" {
+		identifier = req.Email
+	}
+	accountKey := serv.accountLoginKey(identifier)
+	ipKey := serv.ipLoginKey(ctx)
+	if err := serv.checkLoginLock(ctx, accountKey, ipKey); err != nil {
+		return err
 	}
 
 	var user model.User
 	var err error
 	if req.Username != "" {
 		user, err = serv.store.GetUserByUsername(ctx.Request().Context(), req.Username)
-		if err != nil {
-			if err.Error() == db.ErrRecordNotFound {
-				return serv.err(ctx, http.StatusNotFound, "user not found")
-			}
-			return serv.err(ctx, http.StatusInternalServerError, "failed to get user by username")
-		}
 	} else {
 		user, err = serv.store.GetUserByEmail(ctx.Request().Context(), req.Email)
-		if err != nil {
-			if err.Error() == db.ErrRecordNotFound {
-				return serv.err(ctx, http.StatusNotFound, "user not found")
-			}
-			return serv.err(ctx, http.StatusInternalServerError, "failed to get user by email")
+	}
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			// unknown users fail exactly like wrong passwords so that they cannot be told apart
+			security.SimulatePasswordCheck(req.Password)
+			serv.loginFailed(ctx, accountKey, ipKey)
+			return invalidCredentials()
 		}
+		return newError(http.StatusInternalServerError, "failed to get user")
 	}
 
 	err = security.CheckPassword(req.Password, user.Password)
 	if err != nil {
-		return serv.err(ctx, http.StatusUnauthorized, "incorrect password")
+		serv.loginFailed(ctx, accountKey, ipKey)
+		return invalidCredentials()
+	}
+	serv.loginSucceeded(ctx, accountKey)
+	return serv.completeLogin(ctx, &user)
+}
+
+// completeLogin signs in a user whose first factor has been checked, users without a verified
+// email address may be turned away and users with two-factor authentication are challenged
+func (serv *Server) completeLogin(ctx echo.Context, user *model.User) error {
+	if serv.conf.EmailVerification == verificationLogin && !user.EmailVerified {
+		return newError(http.StatusForbidden, "email address is not verified").withCode(codeEmailNotVerified)
+	}
+	if user.TotpEnabled {
+		return serv.mfaChallenge(ctx, user)
 	}
+	return serv.startSession(ctx, user)
+}
 
+// startSession signs an authenticated user in, it creates the session and answers with the
+// credentials of the login response
+func (serv *Server) startSession(ctx echo.Context, user *model.User) error {
+	sessionID, err := uuid.NewRandom()
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to create session")
+	}
 	accessToken, accessPayload, err := serv.token.Generate(
+		security.TokenTypeAccess,
 		user.ID,
 		user.Username,
+		sessionID,
 		serv.conf.AccessTokenDuration,
 	)
 	if err != nil {
-		return serv.err(ctx, http.StatusInternalServerError, "failed to generate access token")
+		return newError(http.StatusInternalServerError, "failed to generate access token")
 	}
 	refreshToken, refreshPayload, err := serv.token.Generate(
+		security.TokenTypeRefresh,
 		user.ID,
 		user.Username,
+		sessionID,
 		serv.conf.RefreshTokenDuration,
 	)
 	if err != nil {
-		return serv.err(ctx, http.StatusInternalServerError, "failed to generate refresh token")
+		return newError(http.StatusInternalServerError, "failed to generate refresh token")
 	}
 
 	arg := db.CreateSessionArg{
-		ID:           refreshPayload.ID,
-		UserID:       refreshPayload.UserID,
-		Username:     refreshPayload.Username,
-		RefreshToken: refreshToken,
-		UserAgent:    ctx.Request().UserAgent(),
-		ClientIp:     ctx.RealIP(),
-		ExpiresAt:    refreshPayload.ExpiresAt,
+		ID:               sessionID,
+		UserID:           refreshPayload.UserID,
+		Username:         refreshPayload.Username,
+		RefreshTokenHash: security.HashToken(refreshToken),
+		UserAgent:        ctx.Request().UserAgent(),
+		ClientIp:         ctx.RealIP(),
+		ExpiresAt:        refreshPayload.ExpiresAt,
 	}
 	session, err := serv.store.CreateSession(ctx.Request().Context(), arg)
 	if err != nil {
-		return serv.err(ctx, http.StatusInternalServerError, "failed to create session")
+		return newError(http.StatusInternalServerError, "failed to create session")
 	}
 
 	credentials := loginCredentials{
@@ -140,7 +184,7 @@ func (serv *Server) login(ctx echo.Context) error {
 		RefreshToken:          refreshToken,
 		RefreshTokenExpiresAt: refreshPayload.ExpiresAt,
 	}
-	return ctx.JSON(http.StatusOK, newLoginResponse(&user, &credentials))
+	return ctx.JSON(http.StatusOK, newLoginResponse(user, &credentials))
 }
 
 type (
@@ -159,12 +203,13 @@ func (serv *Server) login(ctx echo.Context) error {
 	}
 
 	loginUser struct {
-		ID          uint      `json:"id"`
-		Username    string    `json:"username"`
-		Email       string    `json:"email"`
-		DisplayName string    `json:"display_name"`
-		UpdatedAt   time.Time `json:"updated_at"`
-		CreatedAt   time.Time `json:"created_at"`
+		ID            uint      `json:"id"`
+		Username      string    `json:"username"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		DisplayName   string    `json:"display_name"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		CreatedAt     time.Time `json:"created_at"`
 	}
 
 	loginResponse struct {
@@ -177,12 +222,13 @@ func newLoginResponse(user *model.User, credentials *loginCredentials) *loginRes
 	return &loginResponse{
 		Credentials: *credentials,
 		User: loginUser{
-			ID:          user.ID,
-			Username:    user.Username,
-			Email:       user.Email,
-			DisplayName: user.FirstName + " " + user.LastName,
-			UpdatedAt:   user.UpdatedAt,
-			CreatedAt:   user.CreatedAt,
+			ID:            user.ID,
+			Username:      user.Username,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			DisplayName:   user.FirstName + " " + user.LastName,
+			UpdatedAt:     user.UpdatedAt,
+			CreatedAt:     user.CreatedAt,
 		},
 	}
 }
@@ -190,50 +236,120 @@ func newLoginResponse(user *model.User, credentials *loginCredentials) *loginRes
 func (serv *Server) renewAccess(ctx echo.Context) error {
 	var req renewAccessRequest
 	if err := ctx.Bind(&req); err != nil {
-		return serv.err(ctx, http.StatusBadRequest, "invalid request")
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
 	}
 
 	refreshPayload, err := serv.token.Verify(req.RefreshToken)
-	if err != nil {
-		return serv.err(ctx, http.StatusUnauthorized, "invalid refresh token")
+	// refresh tokens from before token types carry none, they are told apart by being stored
+	if err != nil || refreshPayload.Type == security.TokenTypeAccess {
+		return newError(http.StatusUnauthorized, "invalid refresh token")
 	}
 
-	session, err := serv.store.GetSessionByRefreshToken(ctx.Request().Context(), req.RefreshToken)
+	usedHash := security.HashToken(req.RefreshToken)
+	token, err := serv.store.GetRefreshTokenByHash(ctx.Request().Context(), usedHash)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusUnauthorized, "invalid refresh token")
+		}
+		return newError(http.StatusInternalServerError, "failed to get refresh token")
+	}
+	session, err := serv.store.GetSessionByID(ctx.Request().Context(), token.SessionID)
 	if err != nil {
-		if err.Error() == db.ErrRecordNotFound {
-			return serv.err(ctx, http.StatusNotFound, "session not found")
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "session not found")
 		}
-		return serv.err(ctx, http.StatusInternalServerError, "failed to get session by refresh token")
+		return newError(http.StatusInternalServerError, "failed to get session")
 	}
-	if session.Username != refreshPayload.Username {
-		return serv.err(ctx, http.StatusUnauthorized, "incorrect session user")
+	if token.IsUsed() {
+		return serv.refreshTokenReused(ctx, session)
 	}
-	if session.RefreshToken != req.RefreshToken {
-		return serv.err(ctx, http.StatusUnauthorized, "incorrect refresh token")
+	if session.Username != refreshPayload.Username || refreshPayloadSessionID(refreshPayload) != session.ID {
+		return newError(http.StatusUnauthorized, "incorrect session user")
+	}
+	if session.IsBlocked {
+		return newError(http.StatusUnauthorized, "session revoked")
 	}
 	if time.Now().After(session.ExpiresAt) {
-		return serv.err(ctx, http.StatusUnauthorized, "session expired")
+		return newError(http.StatusUnauthorized, "session expired")
 	}
 
 	accessToken, accessPayload, err := serv.token.Generate(
+		security.TokenTypeAccess,
 		refreshPayload.UserID,
 		refreshPayload.Username,
+		session.ID,
 		serv.conf.AccessTokenDuration,
 	)
 	if err != nil {
-		return serv.err(ctx, http.StatusInternalServerError, "failed to generate access token")
+		return newError(http.StatusInternalServerError, "failed to generate access token")
+	}
+	// the session keeps its expiry, rotation does not extend it
+	refreshToken, refreshPayload, err := serv.token.Generate(
+		security.TokenTypeRefresh,
+		refreshPayload.UserID,
+		refreshPayload.Username,
+		session.ID,
+		time.Until(session.ExpiresAt),
+	)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to generate refresh token")
+	}
+
+	arg := db.RotateRefreshTokenArg{
+		SessionID: session.ID,
+		UsedHash:  usedHash,
+		TokenHash: security.HashToken(refreshToken),
+		ExpiresAt: refreshPayload.ExpiresAt,
+	}
+	_, err = serv.store.RotateRefreshToken(ctx.Request().Context(), arg)
+	if err != nil {
+		if errors.Is(err, db.ErrAlreadyUsed) {
+			return serv.refreshTokenReused(ctx, session)
+		}
+		return newError(http.StatusInternalServerError, "failed to rotate refresh token")
+	}
+	if err = serv.store.TouchSession(ctx.Request().Context(), session.ID); err != nil {
+		return newError(http.StatusInternalServerError, "failed to update session")
 	}
 
 	rsp := renewAccessResponse{
 		SessionID:             session.ID.String(),
 		AccessToken:           accessToken,
 		ExpiresAt:             accessPayload.ExpiresAt,
-		RefreshToken:          req.RefreshToken,
+		RefreshToken:          refreshToken,
 		RefreshTokenExpiresAt: refreshPayload.ExpiresAt,
 	}
 	return ctx.JSON(http.StatusOK, rsp)
 }
 
+// refreshPayloadSessionID returns the session a refresh token belongs to. Refresh tokens issued
+// before tokens named their session carry no session_id claim, the session took their id.
+func refreshPayloadSessionID(payload *security.TokenPayload) uuid.UUID {
+	if payload.SessionID == uuid.Nil {
+		return payload.ID
+	}
+	return payload.SessionID
+}
+
+// refreshTokenReused revokes a session whose already rotated refresh token is presented again,
+// either the legitimate client or an attacker holds a stolen copy so neither can be trusted
+func (serv *Server) refreshTokenReused(ctx echo.Context, session model.Session) error {
+	log.Warn().
+		Str("event", "refresh_token_reuse").
+		Str("session_id", session.ID.String()).
+		Uint("user_id", session.UserID).
+		Str("client_ip", ctx.RealIP()).
+		Str("user_agent", ctx.Request().UserAgent()).
+		Msg("refresh token reused, revoking session")
+	if err := serv.store.BlockSession(ctx.Request().Context(), session.ID); err != nil {
+		return newError(http.StatusInternalServerError, "failed to revoke session")
+	}
+	return newError(http.StatusUnauthorized, "refresh token has already been used")
+}
+
 type (
 	renewAccessRequest struct {
 		RefreshToken string `json:"refresh_token" validate:"required"`
@@ -241,3 +357,28 @@ func (serv *Server) renewAccess(ctx echo.Context) error {
 
 	renewAccessResponse loginCredentials
 )
+
+func (serv *Server) logout(ctx echo.Context) error {
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	if payload.SessionID == uuid.Nil {
+		return newError(http.StatusBadRequest, "access token does not belong to a session")
+	}
+
+	err := serv.store.BlockSession(ctx.Request().Context(), payload.SessionID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "session not found")
+		}
+		return newError(http.StatusInternalServerError, "failed to revoke session")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (serv *Server) logoutAll(ctx echo.Context) error {
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	err := serv.store.BlockSessionsByUserID(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to revoke sessions")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
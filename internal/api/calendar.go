@@ -0,0 +1,359 @@
+package api
+
+import (
+	"bytes"
+	"com.github/asdsec/planny/internal/calendar"
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const calendarContentType = "text/calendar; charset=utf-8"
+
+func (serv *Server) exportPlans(ctx echo.Context) error {
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="planny.ics"`)
+	return serv.writeCalendar(ctx, payload.UserID, payload.Username)
+}
+
+func (serv *Server) calendarFeed(ctx echo.Context) error {
+	var req calendarFeedRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	feed, err := serv.store.GetCalendarFeedByTokenHash(ctx.Request().Context(), security.HashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "calendar feed not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve calendar feed")
+	}
+
+	user, err := serv.store.GetUserById(ctx.Request().Context(), feed.UserID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve user")
+	}
+	return serv.writeCalendar(ctx, feed.UserID, user.Username)
+}
+
+func (serv *Server) writeCalendar(ctx echo.Context, userID uint, username string) error {
+	plans, err := serv.store.ListPlansByUserID(ctx.Request().Context(), userID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve plans")
+	}
+
+	var buf bytes.Buffer
+	err = calendar.WriteCalendar(&buf, "Planny - "+username, plans)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot render calendar")
+	}
+	return ctx.Blob(http.StatusOK, calendarContentType, buf.Bytes())
+}
+
+type (
+	calendarFeedRequest struct {
+		Token string `param:"token" validate:"required"`
+	}
+)
+
+func (serv *Server) createCalendarFeed(ctx echo.Context) error {
+	token, err := security.GenerateRandomToken()
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot generate feed secret")
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	arg := db.UpsertCalendarFeedArg{
+		UserID:    payload.UserID,
+		TokenHash: security.HashToken(token),
+	}
+	feed, err := serv.store.UpsertCalendarFeed(ctx.Request().Context(), arg)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot create calendar feed")
+	}
+
+	rsp := createCalendarFeedResponse{
+		URL:       ctx.Scheme() + "://" + ctx.Request().Host + "/api/v1/calendar/feed/" + token,
+		UpdatedAt: feed.UpdatedAt,
+	}
+	return ctx.JSON(http.StatusCreated, rsp)
+}
+
+type (
+	// createCalendarFeedResponse carries the subscription URL, the secret in it is only shown once
+	createCalendarFeedResponse struct {
+		URL       string    `json:"url"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+)
+
+func (serv *Server) deleteCalendarFeed(ctx echo.Context) error {
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	err := serv.store.DeleteCalendarFeedByUserID(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "calendar feed not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot delete calendar feed")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Results of an imported event
+const (
+	importCreated   = "created"
+	importDuplicate = "duplicate"
+	importOverlap   = "overlap"
+	importInvalid   = "invalid"
+)
+
+const maxImportSize = 1 << 20
+
+func (serv *Server) importPlans(ctx echo.Context) error {
+	var req importPlansRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+	if err := echo.QueryParamsBinder(ctx).Bool("dry_run", &req.DryRun).BindError(); err != nil {
+		return newError(http.StatusBadRequest, "invalid dry_run parameter")
+	}
+
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		return newError(http.StatusBadRequest, "file is required")
+	}
+	if header.Size > maxImportSize {
+		return newError(http.StatusRequestEntityTooLarge, "file is too large")
+	}
+	file, err := header.Open()
+	if err != nil {
+		return newError(http.StatusBadRequest, "cannot open file")
+	}
+	defer file.Close()
+
+	events, err := calendar.ParseCalendar(io.LimitReader(file, maxImportSize))
+	if err != nil {
+		return newError(http.StatusBadRequest, err.Error())
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	var rsp importPlansResponse
+	importAll := func(store db.Store) error {
+		var err error
+		rsp, err = serv.importEvents(ctx, store, payload.UserID, events, req.DryRun)
+		return err
+	}
+	if req.DryRun {
+		err = importAll(serv.store)
+	} else {
+		// an import is saved as a whole or not at all
+		err = serv.store.ExecTx(ctx.Request().Context(), importAll)
+	}
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			return apiErr
+		}
+		return newError(http.StatusInternalServerError, "cannot import plans")
+	}
+
+	status := http.StatusOK
+	if !req.DryRun && rsp.Created > 0 {
+		status = http.StatusCreated
+	}
+	return ctx.JSON(status, rsp)
+}
+
+// importEvents creates the plans of the events that are new, valid and free of overlaps and
+// reports the result of every event. A dry run only reports.
+func (serv *Server) importEvents(ctx echo.Context, store db.Store, userID uint, events []calendar.Event, dryRun bool) (importPlansResponse, error) {
+	rsp := importPlansResponse{DryRun: dryRun, Events: []importedEvent{}}
+	plans, err := store.ListPlansByUserID(ctx.Request().Context(), userID)
+	if err != nil {
+		return rsp, newError(http.StatusInternalServerError, "cannot retrieve plans")
+	}
+	seen := map[string]uint{}
+	// existing holds the index in plans of the series stored before the import
+	existing := map[uint]int{}
+	for i := range plans {
+		seen[importKey(calendar.PlanUID(&plans[i]), plans[i].RecurrenceID)] = plans[i].ID
+		if plans[i].IsRecurring() {
+			existing[plans[i].ID] = i
+		}
+	}
+
+	// series are imported before the occurrences detached from them
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].RecurrenceID.IsZero() && !events[j].RecurrenceID.IsZero()
+	})
+	detached := map[string][]time.Time{}
+	for _, event := range events {
+		if !event.RecurrenceID.IsZero() {
+			detached[event.UID] = append(detached[event.UID], event.RecurrenceID)
+		}
+	}
+
+	for _, event := range events {
+		result := importedEvent{
+			UID:       event.UID,
+			Summary:   event.Summary,
+			StartDate: event.StartDate,
+		}
+
+		key := importKey(event.UID, event.RecurrenceID)
+		if id, ok := seen[key]; ok && event.UID != "" {
+			result.Result, result.PlanID = importDuplicate, id
+			rsp.Skipped++
+			rsp.Events = append(rsp.Events, result)
+			continue
+		}
+
+		// events are held to the rules of plans created through the API
+		doc := planDocument{
+			Title:          event.Summary,
+			Description:    event.Description,
+			StartDate:      event.StartDate,
+			EndDate:        event.EndDate,
+			Status:         event.Status,
+			RecurrenceRule: event.RecurrenceRule,
+			ExDates:        event.ExDates,
+		}
+		if event.RecurrenceID.IsZero() {
+			// occurrences detached from a series are no longer generated by it
+			doc.ExDates = append(doc.ExDates[:len(doc.ExDates):len(doc.ExDates)], detached[event.UID]...)
+		}
+		if err := doc.validate(); err != nil {
+			result.Result, result.Error = importInvalid, importError(err)
+			rsp.Rejected++
+			rsp.Events = append(rsp.Events, result)
+			continue
+		}
+
+		arg := db.CreatePlanArg{
+			Title:          doc.Title,
+			Description:    doc.Description,
+			StartDate:      doc.StartDate,
+			EndDate:        doc.EndDate,
+			Status:         db.Status(doc.Status),
+			RecurrenceRule: doc.RecurrenceRule,
+			ExDates:        doc.ExDates,
+			RecurrenceID:   event.RecurrenceID,
+			ExternalUID:    event.UID,
+			UserID:         userID,
+		}
+		var skip func(model.Plan) bool
+		if !event.RecurrenceID.IsZero() {
+			arg.ParentID = seen[importKey(event.UID, time.Time{})]
+			// the occurrence the event replaces does not count as an overlap
+			skip = func(plan model.Plan) bool {
+				return plan.ID == arg.ParentID && plan.RecurrenceID.Equal(event.RecurrenceID)
+			}
+		}
+		candidate := model.Plan{
+			StartDate:      arg.StartDate,
+			EndDate:        arg.EndDate,
+			RecurrenceRule: arg.RecurrenceRule,
+			ExDates:        arg.ExDates,
+		}
+		overlap, err := planOverlaps(candidate, plans, skip)
+		if err != nil {
+			return rsp, newError(http.StatusInternalServerError, "cannot check plan date overlap")
+		}
+		if overlap {
+			result.Result = importOverlap
+			rsp.Rejected++
+			rsp.Events = append(rsp.Events, result)
+			continue
+		}
+
+		// a series stored before the import stops generating the occurrence the event replaces,
+		// a series of the import already excluded it when it was created
+		if i, ok := existing[arg.ParentID]; ok && arg.ParentID != 0 && calendar.IsOccurrence(plans[i], event.RecurrenceID) {
+			exDates := append(plans[i].ExDates[:len(plans[i].ExDates):len(plans[i].ExDates)], event.RecurrenceID)
+			if !dryRun {
+				_, err = store.UpdatePlanByID(ctx.Request().Context(), db.UpdatePlanArg{ID: arg.ParentID, ExDates: exDates})
+				if err != nil {
+					return rsp, newError(http.StatusInternalServerError, "cannot update plan")
+				}
+			}
+			plans[i].ExDates = exDates
+		}
+
+		if !dryRun {
+			plan, err := store.CreatePlan(ctx.Request().Context(), arg)
+			if err != nil {
+				return rsp, newError(http.StatusInternalServerError, "cannot create plan")
+			}
+			candidate, result.PlanID = plan, plan.ID
+		}
+		plans = append(plans, candidate)
+		if event.UID != "" {
+			seen[key] = result.PlanID
+		}
+		result.Result = importCreated
+		rsp.Created++
+		rsp.Events = append(rsp.Events, result)
+	}
+	return rsp, nil
+}
+
+// importError describes why an event is invalid in the terms of the iCalendar properties it
+// came from
+func importError(err error) string {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err.Error()
+	}
+	fe := errs[0]
+	switch fe.StructField() {
+	case "Title":
+		return "SUMMARY " + validationMessage(fe)
+	case "EndDate":
+		return "DTEND must be after DTSTART"
+	}
+	return fe.Field() + " " + validationMessage(fe)
+}
+
+func importKey(uid string, recurrenceID time.Time) string {
+	if recurrenceID.IsZero() {
+		return uid
+	}
+	return uid + "|" + calendar.FormatDateTime(recurrenceID)
+}
+
+type (
+	importPlansRequest struct {
+		DryRun bool `form:"dry_run"`
+	}
+
+	importedEvent struct {
+		UID       string    `json:"uid"`
+		Summary   string    `json:"summary"`
+		StartDate time.Time `json:"start_date"`
+		Result    string    `json:"result"`
+		Error     string    `json:"error,omitempty"`
+		PlanID    uint      `json:"plan_id,omitempty"`
+	}
+
+	importPlansResponse struct {
+		DryRun   bool            `json:"dry_run"`
+		Created  int             `json:"created"`
+		Skipped  int             `json:"skipped"`
+		Rejected int             `json:"rejected"`
+		Events   []importedEvent `json:"events"`
+	}
+)
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/mail"
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of EMAIL_VERIFICATION, telling what unverified accounts are kept from
+const (
+	verificationOptional = "optional"
+	verificationLogin    = "login"
+	verificationPlans    = "plans"
+)
+
+const emailVerificationPurpose = "email_verification"
+
+// sendEmailVerification emails the user a signed link confirming its address. The link names
+// the address so that it stops working once the user changes it.
+func (serv *Server) sendEmailVerification(user model.User) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+
+	expiresAt := time.Now().Add(serv.conf.EmailVerificationDuration)
+	token := serv.signer.Sign(emailVerificationPurpose, fmt.Sprintf("%d:%s", user.ID, user.Email), expiresAt)
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Planny email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to verify your email address:\n\n%s\n\n"+
+			"The link expires in %s.\n",
+			user.FirstName, serv.appLink("/verify-email", token), serv.conf.EmailVerificationDuration),
+	}
+	if err := serv.mailer.Send(ctx, msg); err != nil {
+		log.Error().Err(err).Uint("user_id", user.ID).Msg("cannot send verification email")
+	}
+}
+
+// resendEmailVerification sends a new verification link, it answers the same whether or not
+// the email is registered or already verified
+func (serv *Server) resendEmailVerification(ctx echo.Context) error {
+	var req resendEmailVerificationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	if err := serv.limitEmailRequests(ctx, loginKeyEmailVerification, req.Email, "too many verification emails requested, try again later"); err != nil {
+		return err
+	}
+	go func() {
+		user, err := serv.store.GetUserByEmail(context.Background(), req.Email)
+		if err != nil {
+			if !errors.Is(err, db.ErrRecordNotFound) {
+				log.Error().Err(err).Msg("cannot get user for email verification")
+			}
+			return
+		}
+		if !user.EmailVerified {
+			serv.sendEmailVerification(user)
+		}
+	}()
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+type (
+	resendEmailVerificationRequest struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+)
+
+func (serv *Server) verifyEmail(ctx echo.Context) error {
+	var req verifyEmailRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	value, err := serv.signer.Verify(emailVerificationPurpose, req.Token)
+	if err != nil {
+		if errors.Is(err, security.ErrExpiredToken) {
+			return newError(http.StatusBadRequest, "verification link has expired").withCode(codeInvalidToken)
+		}
+		return newError(http.StatusBadRequest, "verification link is invalid").withCode(codeInvalidToken)
+	}
+	rawID, email, _ := strings.Cut(value, ":")
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		return newError(http.StatusBadRequest, "verification link is invalid").withCode(codeInvalidToken)
+	}
+
+	user, err := serv.store.GetUserById(ctx.Request().Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusBadRequest, "verification link is invalid").withCode(codeInvalidToken)
+		}
+		return newError(http.StatusInternalServerError, "failed to get user")
+	}
+	if user.Email != email {
+		return newError(http.StatusBadRequest, "verification link is invalid").withCode(codeInvalidToken)
+	}
+	if !user.EmailVerified {
+		if err = serv.store.MarkUserEmailVerified(ctx.Request().Context(), user.ID); err != nil {
+			return newError(http.StatusInternalServerError, "failed to verify email")
+		}
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type (
+	verifyEmailRequest struct {
+		Token string `json:"token" validate:"required"`
+	}
+)
+
+// requireVerifiedEmail keeps unverified accounts from the routes it guards when
+// EMAIL_VERIFICATION=plans
+func (serv *Server) requireVerifiedEmail(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		if serv.conf.EmailVerification != verificationPlans {
+			return next(ctx)
+		}
+		payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+		user, err := serv.store.GetUserById(ctx.Request().Context(), payload.UserID)
+		if err != nil {
+			if errors.Is(err, db.ErrRecordNotFound) {
+				return newError(http.StatusUnauthorized, "user not found")
+			}
+			return newError(http.StatusInternalServerError, "failed to get user")
+		}
+		if !user.EmailVerified {
+			return newError(http.StatusForbidden, "email address is not verified").withCode(codeEmailNotVerified)
+		}
+		return next(ctx)
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"strings"
+)
+
+// planETag derives the entity tag of a plan from its version
+func planETag(plan *model.Plan) string {
+	return fmt.Sprintf(`"%d-%d"`, plan.ID, plan.Version)
+}
+
+// setPlanETag sends the entity tag of plan with the response
+func setPlanETag(ctx echo.Context, plan *model.Plan) {
+	ctx.Response().Header().Set("ETag", planETag(plan))
+}
+
+// etagMatchesWeak reports whether an If-None-Match header lists etag, weak validators compare
+// equal to their strong counterpart
+func etagMatchesWeak(header, etag string) bool {
+	return etagMatches(header, etag, true)
+}
+
+// etagMatchesStrong reports whether an If-Match header lists etag, a weak validator never
+// matches as RFC 9110 requires the strong comparison for writes
+func etagMatchesStrong(header, etag string) bool {
+	return etagMatches(header, etag, false)
+}
+
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion returns the plan version the If-Match header of the request pins the write
+// to, zero when the header is absent or "*". ok is false when the header already fails to
+// match the plan.
+func ifMatchVersion(ctx echo.Context, plan *model.Plan) (version uint, ok bool) {
+	header := strings.TrimSpace(ctx.Request().Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	if !etagMatchesStrong(header, planETag(plan)) {
+		return 0, false
+	}
+	return plan.Version, true
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/calendar"
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultFreeSlotWindow = 7 * 24 * time.Hour
+	maxFreeSlotWindow     = 92 * 24 * time.Hour
+	defaultFreeSlotLimit  = 20
+	// suggestionWindow is searched on both sides of a rejected plan for alternatives
+	suggestionWindow = 7 * 24 * time.Hour
+)
+
+func (serv *Server) retrieveFreeSlots(ctx echo.Context) error {
+	var req retrieveFreeSlotsRequest
+	err := echo.QueryParamsBinder(ctx).
+		MustDuration("duration", &req.Duration).
+		Time("from", &req.From, time.RFC3339).
+		Time("to", &req.To, time.RFC3339).
+		String("work_start", &req.WorkStart).
+		String("work_end", &req.WorkEnd).
+		String("tz", &req.TimeZone).
+		Int("limit", &req.Limit).
+		BindError()
+	if err != nil {
+		return newError(http.StatusBadRequest, "invalid query parameters")
+	}
+	if req.Duration <= 0 {
+		return newError(http.StatusBadRequest, "duration must be positive")
+	}
+	if req.From.IsZero() {
+		req.From = time.Now()
+	}
+	if req.To.IsZero() {
+		req.To = req.From.Add(defaultFreeSlotWindow)
+	}
+	if !req.To.After(req.From) || req.To.Sub(req.From) > maxFreeSlotWindow {
+		return newError(http.StatusBadRequest, "from and to must form a window of at most 92 days")
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultFreeSlotLimit
+	}
+	hours, err := req.workingHours()
+	if err != nil {
+		return newError(http.StatusBadRequest, err.Error())
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	plans, err := serv.store.ListPlansByUserID(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve plans")
+	}
+
+	slots, err := calendar.FreeSlots(plans, req.From, req.To, req.Duration, hours)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot compute free slots")
+	}
+	if len(slots) > req.Limit {
+		slots = slots[:req.Limit]
+	}
+	return ctx.JSON(http.StatusOK, retrieveFreeSlotsResponse{Slots: newFreeSlotModels(slots)})
+}
+
+type (
+	retrieveFreeSlotsRequest struct {
+		Duration  time.Duration
+		From      time.Time
+		To        time.Time
+		WorkStart string
+		WorkEnd   string
+		TimeZone  string
+		Limit     int
+	}
+
+	freeSlotModel struct {
+		StartDate time.Time `json:"start_date"`
+		EndDate   time.Time `json:"end_date"`
+	}
+
+	retrieveFreeSlotsResponse struct {
+		Slots []freeSlotModel `json:"slots"`
+	}
+)
+
+func (req *retrieveFreeSlotsRequest) workingHours() (*calendar.WorkingHours, error) {
+	if req.WorkStart == "" && req.WorkEnd == "" {
+		return nil, nil
+	}
+	start, err := parseClock(req.WorkStart)
+	if err != nil {
+		return nil, fmt.Errorf("invalid work_start: %w", err)
+	}
+	end, err := parseClock(req.WorkEnd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid work_end: %w", err)
+	}
+	if end <= start {
+		return nil, fmt.Errorf("work_end must be after work_start")
+	}
+	loc := time.UTC
+	if req.TimeZone != "" {
+		loc, err = time.LoadLocation(req.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone %s", req.TimeZone)
+		}
+	}
+	return &calendar.WorkingHours{Start: start, End: end, Location: loc}, nil
+}
+
+// parseClock parses a HH:MM time of day into an offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM")
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func newFreeSlotModels(slots []calendar.Slot) []freeSlotModel {
+	models := make([]freeSlotModel, 0, len(slots))
+	for _, slot := range slots {
+		models = append(models, freeSlotModel{StartDate: slot.Start, EndDate: slot.End})
+	}
+	return models
+}
+
+// suggestFreeSlots returns up to limit placements for candidate that do not overlap plans
+func suggestFreeSlots(candidate model.Plan, plans []model.Plan, limit int) ([]freeSlotModel, error) {
+	duration := candidate.EndDate.Sub(candidate.StartDate)
+	from := candidate.StartDate.Add(-suggestionWindow)
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+	to := candidate.StartDate.Add(suggestionWindow)
+	if !to.After(from) {
+		return []freeSlotModel{}, nil
+	}
+
+	free, err := calendar.FreeSlots(plans, from, to, duration, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newFreeSlotModels(calendar.NearestSlots(free, candidate.StartDate, duration, limit)), nil
+}
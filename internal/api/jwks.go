@@ -0,0 +1,17 @@
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// retrieveJWKS publishes the public keys that verify access and refresh tokens, there is
+// nothing to publish while tokens are signed with the symmetric key
+func (serv *Server) retrieveJWKS(ctx echo.Context) error {
+	if serv.keys == nil {
+		return newError(http.StatusNotFound, "tokens are not signed with public keys")
+	}
+	ctx.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=300")
+	return ctx.JSON(http.StatusOK, serv.keys.JWKS(time.Now()))
+}
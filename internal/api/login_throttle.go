@@ -0,0 +1,132 @@
+package api
+
+import (
+	db "com.github/asdsec/planny/internal/store"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefixes of the keys failed logins are counted under, requested emails are counted the same
+// way
+const (
+	loginKeyAccount           = "account:"
+	loginKeyMfa               = "mfa:"
+	loginKeyIP                = "ip:"
+	loginKeyMagicLink         = "magic_link:"
+	loginKeyPasswordReset     = "password_reset:"
+	loginKeyEmailVerification = "email_verification:"
+)
+
+// loginKey is a key failed logins are counted under together with the number of failures
+// that locks it
+type loginKey struct {
+	key         string
+	maxAttempts uint
+}
+
+func (serv *Server) accountLoginKey(identifier string) loginKey {
+	return loginKey{loginKeyAccount + strings.ToLower(identifier), serv.conf.LoginMaxAttempts}
+}
+
+func (serv *Server) mfaLoginKey(userID uint) loginKey {
+	return loginKey{loginKeyMfa + strconv.FormatUint(uint64(userID), 10), serv.conf.LoginMaxAttempts}
+}
+
+func (serv *Server) ipLoginKey(ctx echo.Context) loginKey {
+	return loginKey{loginKeyIP + ctx.RealIP(), serv.conf.LoginMaxIPAttempts}
+}
+
+// checkLoginLock refuses a login while any of its keys is locked out
+func (serv *Server) checkLoginLock(ctx echo.Context, keys ...loginKey) error {
+	var lockedUntil time.Time
+	for _, key := range keys {
+		attempt, err := serv.store.GetLoginAttempt(ctx.Request().Context(), key.key)
+		if err != nil {
+			if errors.Is(err, db.ErrRecordNotFound) {
+				continue
+			}
+			return newError(http.StatusInternalServerError, "failed to check login attempts")
+		}
+		if attempt.IsLocked(time.Now()) && attempt.LockedUntil.After(lockedUntil) {
+			lockedUntil = attempt.LockedUntil
+		}
+	}
+	if lockedUntil.IsZero() {
+		return nil
+	}
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	ctx.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	return newError(http.StatusTooManyRequests, "too many failed login attempts, try again later").
+		with("retry_after", retryAfter)
+}
+
+// loginFailed counts a failed login under each of its keys and locks the keys that ran out of
+// attempts. Every failure past the limit doubles the lockout.
+func (serv *Server) loginFailed(ctx echo.Context, keys ...loginKey) {
+	for _, key := range keys {
+		attempt, err := serv.store.RecordLoginFailure(ctx.Request().Context(), key.key, serv.conf.LoginMaxLockoutDuration)
+		if err != nil {
+			log.Error().Err(err).Str("key", key.key).Msg("cannot record failed login")
+			continue
+		}
+		if attempt.Failures < key.maxAttempts {
+			continue
+		}
+
+		lockout := serv.conf.LoginMaxLockoutDuration
+		if exponent := attempt.Failures - key.maxAttempts; exponent < 32 {
+			lockout = min(serv.conf.LoginLockoutDuration<<exponent, lockout)
+		}
+		arg := db.LockLoginArg{
+			Key:         key.key,
+			Failures:    attempt.Failures,
+			ClientIp:    ctx.RealIP(),
+			UserAgent:   ctx.Request().UserAgent(),
+			LockedUntil: time.Now().Add(lockout),
+		}
+		if err = serv.store.LockLogin(ctx.Request().Context(), arg); err != nil {
+			log.Error().Err(err).Str("key", key.key).Msg("cannot lock login")
+			continue
+		}
+		log.Warn().
+			Str("event", "login_lockout").
+			Str("key", key.key).
+			Uint("failures", attempt.Failures).
+			Str("client_ip", ctx.RealIP()).
+			Str("locked_for", lockout.String()).
+			Msg("login locked out")
+	}
+}
+
+// loginSucceeded forgets the failed logins of a key
+func (serv *Server) loginSucceeded(ctx echo.Context, key loginKey) {
+	if err := serv.store.ResetLoginAttempts(ctx.Request().Context(), key.key); err != nil {
+		log.Error().Err(err).Str("key", key.key).Msg("cannot reset failed logins")
+	}
+}
+
+// limitEmailRequests counts a request for an email to an address under prefix and refuses it
+// once more than MagicLinkMaxRequests were made within MagicLinkWindow. Unknown addresses are
+// counted too so that the answer does not tell whether an address is registered.
+func (serv *Server) limitEmailRequests(ctx echo.Context, prefix, email, detail string) error {
+	attempt, err := serv.store.RecordLoginFailure(ctx.Request().Context(), prefix+strings.ToLower(email), serv.conf.MagicLinkWindow)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to check email requests")
+	}
+	if attempt.Failures > serv.conf.MagicLinkMaxRequests {
+		retryAfter := int(math.Ceil(serv.conf.MagicLinkWindow.Seconds()))
+		ctx.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		return newError(http.StatusTooManyRequests, detail).with("retry_after", retryAfter)
+	}
+	return nil
+}
+
+func invalidCredentials() error {
+	return newError(http.StatusUnauthorized, "invalid username, email or password").withCode(codeInvalidCredentials)
+}
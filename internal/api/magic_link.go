@@ -0,0 +1,129 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/mail"
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"time"
+)
+
+const magicLinkPurpose = "magic_link"
+
+// magicLinkSignPurpose binds a link to the user agent that asked for it, a link opened in
+// another browser fails the signature check
+func magicLinkSignPurpose(userAgent string) string {
+	return magicLinkPurpose + ":" + security.HashToken(userAgent)
+}
+
+// requestMagicLink emails a link that logs in without a password. Like forgotPassword it
+// answers the same whether or not the email is registered, only the rate limit of the email
+// address is enforced up front.
+func (serv *Server) requestMagicLink(ctx echo.Context) error {
+	var req requestMagicLinkRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	if err := serv.limitEmailRequests(ctx, loginKeyMagicLink, req.Email, "too many login links requested, try again later"); err != nil {
+		return err
+	}
+
+	go serv.sendMagicLink(req.Email, ctx.Request().UserAgent())
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+type (
+	requestMagicLinkRequest struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+)
+
+func (serv *Server) sendMagicLink(email, userAgent string) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+
+	user, err := serv.store.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, db.ErrRecordNotFound) {
+			log.Error().Err(err).Msg("cannot get user for login link")
+		}
+		return
+	}
+	expiresAt := time.Now().Add(serv.conf.MagicLinkDuration)
+	token, err := serv.issueOneTimeToken(ctx, user.ID, model.MagicLinkToken, expiresAt)
+	if err != nil {
+		log.Error().Err(err).Uint("user_id", user.ID).Msg("cannot issue login link token")
+		return
+	}
+	link := serv.appLink("/magic-login", serv.signer.Sign(magicLinkSignPurpose(userAgent), token, expiresAt))
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Your Planny login link",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below in the same browser you asked for it from to log in:\n\n%s\n\n"+
+			"The link works once and expires in %s. If you did not ask to log in you can ignore this email.\n",
+			user.FirstName, link, serv.conf.MagicLinkDuration),
+	}
+	if err = serv.mailer.Send(ctx, msg); err != nil {
+		log.Error().Err(err).Uint("user_id", user.ID).Msg("cannot send login link email")
+	}
+}
+
+// loginMagicLink exchanges the token of an emailed link for the login response, opening the
+// link proves the email address as well
+func (serv *Server) loginMagicLink(ctx echo.Context) error {
+	var req loginMagicLinkRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	// the signature is checked first so that a link opened in another browser is not used up
+	token, err := serv.signer.Verify(magicLinkSignPurpose(ctx.Request().UserAgent()), req.Token)
+	if err != nil {
+		return invalidMagicLink()
+	}
+	var user model.User
+	err = serv.store.ExecTx(ctx.Request().Context(), func(store db.Store) error {
+		oneTimeToken, err := store.ConsumeOneTimeToken(ctx.Request().Context(), model.MagicLinkToken, security.HashToken(token))
+		if err != nil {
+			return err
+		}
+		if user, err = store.GetUserById(ctx.Request().Context(), oneTimeToken.UserID); err != nil {
+			return err
+		}
+		if user.EmailVerified {
+			return nil
+		}
+		user.EmailVerified = true
+		return store.MarkUserEmailVerified(ctx.Request().Context(), user.ID)
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) || errors.Is(err, db.ErrAlreadyUsed) {
+			return invalidMagicLink()
+		}
+		return newError(http.StatusInternalServerError, "failed to log in")
+	}
+	return serv.completeLogin(ctx, &user)
+}
+
+type (
+	loginMagicLinkRequest struct {
+		Token string `json:"token" validate:"required"`
+	}
+)
+
+func invalidMagicLink() error {
+	return newError(http.StatusBadRequest, "login link is invalid, expired or opened in another browser").withCode(codeInvalidToken)
+}
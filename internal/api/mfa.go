@@ -0,0 +1,286 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"com.github/asdsec/planny/internal/totp"
+	"encoding/base64"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/skip2/go-qrcode"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mfaChallengePurpose = "mfa_challenge"
+	totpIssuer          = "Planny"
+	recoveryCodeCount   = 10
+	qrCodeSize          = 256
+)
+
+// mfaChallenge answers a correct password of a user with two-factor authentication by a short
+// lived token, the session is only created once the token is exchanged with a code
+func (serv *Server) mfaChallenge(ctx echo.Context, user *model.User) error {
+	expiresAt := time.Now().Add(serv.conf.MfaChallengeDuration)
+	token := serv.signer.Sign(mfaChallengePurpose, strconv.FormatUint(uint64(user.ID), 10), expiresAt)
+	rsp := mfaChallengeResponse{
+		MfaRequired: true,
+		MfaToken:    token,
+		ExpiresAt:   expiresAt,
+	}
+	return ctx.JSON(http.StatusOK, rsp)
+}
+
+type (
+	mfaChallengeResponse struct {
+		MfaRequired bool      `json:"mfa_required"`
+		MfaToken    string    `json:"mfa_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}
+)
+
+// loginMfa completes a login started with a password, it takes the challenge token together
+// with a TOTP or recovery code
+func (serv *Server) loginMfa(ctx echo.Context) error {
+	var req loginMfaRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	value, err := serv.signer.Verify(mfaChallengePurpose, req.MfaToken)
+	if err != nil {
+		if errors.Is(err, security.ErrExpiredToken) {
+			return newError(http.StatusUnauthorized, "mfa token has expired")
+		}
+		return newError(http.StatusUnauthorized, "invalid mfa token")
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return newError(http.StatusUnauthorized, "invalid mfa token")
+	}
+	user, err := serv.store.GetUserById(ctx.Request().Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusUnauthorized, "invalid mfa token")
+		}
+		return newError(http.StatusInternalServerError, "failed to get user")
+	}
+	if !user.TotpEnabled {
+		return newError(http.StatusUnauthorized, "invalid mfa token")
+	}
+	mfaKey := serv.mfaLoginKey(user.ID)
+	ipKey := serv.ipLoginKey(ctx)
+	if err = serv.checkLoginLock(ctx, mfaKey, ipKey); err != nil {
+		return err
+	}
+
+	ok, err := serv.verifySecondFactor(ctx, &user, req.Code)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to verify code")
+	}
+	if !ok {
+		serv.loginFailed(ctx, mfaKey, ipKey)
+		return newError(http.StatusUnauthorized, "invalid code").withCode(codeInvalidMfaCode)
+	}
+	serv.loginSucceeded(ctx, mfaKey)
+	return serv.startSession(ctx, &user)
+}
+
+type (
+	loginMfaRequest struct {
+		MfaToken string `json:"mfa_token" validate:"required"`
+		Code     string `json:"code" validate:"required"`
+	}
+)
+
+// verifySecondFactor accepts a TOTP code of the user or one of its recovery codes, every code
+// is accepted only once
+func (serv *Server) verifySecondFactor(ctx echo.Context, user *model.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if counter, ok := totp.Validate(user.TotpSecret, code, time.Now()); ok {
+		err := serv.store.UseTotpCounter(ctx.Request().Context(), user.ID, counter)
+		if errors.Is(err, db.ErrAlreadyUsed) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	err := serv.store.UseRecoveryCode(ctx.Request().Context(), user.ID, security.HashToken(security.NormalizeRecoveryCode(code)))
+	if errors.Is(err, db.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// enrollTotp starts enabling two-factor authentication, the returned secret has to be confirmed
+// with a first code before login asks for codes. Starting again replaces a pending secret.
+func (serv *Server) enrollTotp(ctx echo.Context) error {
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	user, err := serv.store.GetUserById(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "user not found")
+		}
+		return newError(http.StatusInternalServerError, "failed to get user")
+	}
+	if user.TotpEnabled {
+		return newError(http.StatusConflict, "two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to generate secret")
+	}
+	uri := totp.URI(totpIssuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to generate qr code")
+	}
+	if err = serv.store.SetUserTotpSecret(ctx.Request().Context(), user.ID, secret); err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusConflict, "two-factor authentication is already enabled")
+		}
+		return newError(http.StatusInternalServerError, "failed to store secret")
+	}
+
+	rsp := enrollTotpResponse{
+		Secret: secret,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}
+	return ctx.JSON(http.StatusOK, rsp)
+}
+
+type (
+	enrollTotpResponse struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+		// QRCode is a PNG data URI of the uri that authenticator apps can scan
+		QRCode string `json:"qr_code"`
+	}
+)
+
+// confirmTotp enables two-factor authentication with a first code of the pending secret and
+// returns the recovery codes, they are shown this one time only
+func (serv *Server) confirmTotp(ctx echo.Context) error {
+	var req confirmTotpRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	user, err := serv.store.GetUserById(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "user not found")
+		}
+		return newError(http.StatusInternalServerError, "failed to get user")
+	}
+	if user.TotpEnabled {
+		return newError(http.StatusConflict, "two-factor authentication is already enabled")
+	}
+	if user.TotpSecret == "" {
+		return newError(http.StatusConflict, "two-factor authentication enrollment has not been started")
+	}
+	mfaKey := serv.mfaLoginKey(user.ID)
+	if err = serv.checkLoginLock(ctx, mfaKey); err != nil {
+		return err
+	}
+	counter, ok := totp.Validate(user.TotpSecret, req.Code, time.Now())
+	if !ok {
+		serv.loginFailed(ctx, mfaKey)
+		return newError(http.StatusBadRequest, "invalid code").withCode(codeInvalidMfaCode)
+	}
+	serv.loginSucceeded(ctx, mfaKey)
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := security.GenerateRecoveryCode()
+		if err != nil {
+			return newError(http.StatusInternalServerError, "failed to generate recovery codes")
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, security.HashToken(security.NormalizeRecoveryCode(code)))
+	}
+	arg := db.EnableUserTotpArg{
+		UserID:             user.ID,
+		Counter:            counter,
+		RecoveryCodeHashes: hashes,
+	}
+	if err = serv.store.EnableUserTotp(ctx.Request().Context(), arg); err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusConflict, "two-factor authentication is already enabled")
+		}
+		return newError(http.StatusInternalServerError, "failed to enable two-factor authentication")
+	}
+	return ctx.JSON(http.StatusOK, confirmTotpResponse{RecoveryCodes: codes})
+}
+
+type (
+	confirmTotpRequest struct {
+		Code string `json:"code" validate:"required,len=6,numeric"`
+	}
+
+	confirmTotpResponse struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+)
+
+// disableTotp turns two-factor authentication off, it asks for a fresh TOTP or recovery code so
+// that a stolen access token alone cannot do it
+func (serv *Server) disableTotp(ctx echo.Context) error {
+	var req disableTotpRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	user, err := serv.store.GetUserById(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "user not found")
+		}
+		return newError(http.StatusInternalServerError, "failed to get user")
+	}
+	if !user.TotpEnabled {
+		return newError(http.StatusConflict, "two-factor authentication is not enabled")
+	}
+	// codes are limited like at login, otherwise a stolen access token could guess its way in
+	mfaKey := serv.mfaLoginKey(user.ID)
+	if err = serv.checkLoginLock(ctx, mfaKey); err != nil {
+		return err
+	}
+	ok, err := serv.verifySecondFactor(ctx, &user, req.Code)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to verify code")
+	}
+	if !ok {
+		serv.loginFailed(ctx, mfaKey)
+		return newError(http.StatusForbidden, "invalid code").withCode(codeInvalidMfaCode)
+	}
+	serv.loginSucceeded(ctx, mfaKey)
+
+	if err = serv.store.DisableUserTotp(ctx.Request().Context(), user.ID); err != nil {
+		return newError(http.StatusInternalServerError, "failed to disable two-factor authentication")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type (
+	disableTotpRequest struct {
+		Code string `json:"code" validate:"required"`
+	}
+)
@@ -1,10 +1,14 @@
 package api
 
 import (
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const (
@@ -13,37 +17,56 @@
 	authorizationPayloadKey = "authorization_payload"
 )
 
-func errJson(ctx echo.Context, code int, message string) error {
-	return ctx.JSON(code, echo.Map{
-		"error": echo.Map{
-			"code":    code,
-			"message": message,
-		},
-	})
-}
-
 func (serv *Server) authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		authorizationHeader := ctx.Request().Header.Get(authorizationHeaderKey)
 
 		if len(authorizationHeader) == 0 {
-			return errJson(ctx, http.StatusUnauthorized, "authorization header is not provided")
+			return newError(http.StatusUnauthorized, "authorization header is not provided")
 		}
 
 		fields := strings.Fields(authorizationHeader)
 		if len(fields) < 2 {
-			return errJson(ctx, http.StatusUnauthorized, "invalid authorization header format")
+			return newError(http.StatusUnauthorized, "invalid authorization header format")
 		}
 
 		authorizationType := strings.ToLower(fields[0])
 		if authorizationType != authorizationTypeBearer {
-			return errJson(ctx, http.StatusUnauthorized, fmt.Sprintf("unsupported authorization type %s", authorizationType))
+			return newError(http.StatusUnauthorized, fmt.Sprintf("unsupported authorization type %s", authorizationType))
 		}
 
 		accessToken := fields[1]
+		if strings.HasPrefix(accessToken, personalAccessTokenPrefix) {
+			payload, err := serv.authorizePersonalAccessToken(ctx, accessToken)
+			if err != nil {
+				return err
+			}
+			ctx.Set(authorizationPayloadKey, payload)
+			return next(ctx)
+		}
+
 		payload, err := serv.token.Verify(accessToken)
 		if err != nil {
-			return errJson(ctx, http.StatusUnauthorized, "cannot verify access token")
+			return newError(http.StatusUnauthorized, "cannot verify access token")
+		}
+		// a refresh token outlives rotation and logout, it must never authorize a request
+		if payload.Type != security.TokenTypeAccess {
+			return newError(http.StatusUnauthorized, "token is not an access token")
+		}
+		if serv.conf.StrictSessionCheck {
+			session, err := serv.store.GetSessionByID(ctx.Request().Context(), payload.SessionID)
+			if err != nil {
+				if errors.Is(err, db.ErrRecordNotFound) {
+					return newError(http.StatusUnauthorized, "session not found")
+				}
+				return newError(http.StatusInternalServerError, "cannot verify session")
+			}
+			if session.IsBlocked {
+				return newError(http.StatusUnauthorized, "session revoked")
+			}
+			if time.Now().After(session.ExpiresAt) {
+				return newError(http.StatusUnauthorized, "session expired")
+			}
 		}
 
 		ctx.Set(authorizationPayloadKey, payload)
@@ -0,0 +1,334 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/oidc"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	oidcFlowPurpose  = "oidc_flow"
+	oidcFlowCookie   = "planny_oidc"
+	oidcFlowDuration = 10 * time.Minute
+	oidcPathPrefix   = "/api/v1/oidc"
+	maxUsernameSize  = 32
+	maxNameSize      = 32
+)
+
+// oidcFlow is what the browser keeps in a signed cookie while it is away at the provider. The
+// PKCE verifier never leaves the cookie so an intercepted code cannot be exchanged by others.
+type oidcFlow struct {
+	Provider string `json:"p"`
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+}
+
+// oidcRedirectURL is where a provider sends students back to
+func oidcRedirectURL(apiURL, provider string) string {
+	return strings.TrimSuffix(apiURL, "/") + oidcPathPrefix + "/" + provider + "/callback"
+}
+
+func (serv *Server) retrieveOidcProviders(ctx echo.Context) error {
+	rsp := retrieveOidcProvidersResponse{Providers: make([]oidcProviderModel, 0, len(serv.providers))}
+	for name := range serv.providers {
+		rsp.Providers = append(rsp.Providers, oidcProviderModel{
+			Name:     name,
+			LoginURL: strings.TrimSuffix(serv.conf.APIURL, "/") + oidcPathPrefix + "/" + name + "/login",
+		})
+	}
+	sort.Slice(rsp.Providers, func(i, j int) bool {
+		return rsp.Providers[i].Name < rsp.Providers[j].Name
+	})
+	return ctx.JSON(http.StatusOK, rsp)
+}
+
+type (
+	oidcProviderModel struct {
+		Name     string `json:"name"`
+		LoginURL string `json:"login_url"`
+	}
+
+	retrieveOidcProvidersResponse struct {
+		Providers []oidcProviderModel `json:"providers"`
+	}
+)
+
+// oidcLogin sends the browser to the provider to sign in with the authorization code flow
+func (serv *Server) oidcLogin(ctx echo.Context) error {
+	var req oidcLoginRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+	provider, ok := serv.providers[req.Provider]
+	if !ok {
+		return newError(http.StatusNotFound, "provider not found")
+	}
+
+	flow := oidcFlow{Provider: provider.Name()}
+	for _, value := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		var err error
+		if *value, err = security.GenerateRandomToken(); err != nil {
+			return newError(http.StatusInternalServerError, "failed to start login")
+		}
+	}
+	authURL, err := provider.AuthCodeURL(ctx.Request().Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		log.Error().Err(err).Str("provider", provider.Name()).Msg("cannot start oidc login")
+		return newError(http.StatusBadGateway, "provider is unavailable")
+	}
+
+	b, err := json.Marshal(flow)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to start login")
+	}
+	expiresAt := time.Now().Add(oidcFlowDuration)
+	ctx.SetCookie(&http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    serv.signer.Sign(oidcFlowPurpose, string(b), expiresAt),
+		Path:     oidcPathPrefix,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   ctx.Scheme() == "https",
+		// the provider sends the browser back with a top level navigation, which lax allows
+		SameSite: http.SameSiteLaxMode,
+	})
+	return ctx.Redirect(http.StatusFound, authURL)
+}
+
+type (
+	oidcLoginRequest struct {
+		Provider string `param:"provider" validate:"required"`
+	}
+)
+
+// oidcCallback completes a login at a provider, it answers like a login with a password
+func (serv *Server) oidcCallback(ctx echo.Context) error {
+	var req oidcCallbackRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+	provider, ok := serv.providers[req.Provider]
+	if !ok {
+		return newError(http.StatusNotFound, "provider not found")
+	}
+
+	cookie, err := ctx.Cookie(oidcFlowCookie)
+	if err != nil {
+		return newError(http.StatusBadRequest, "login has expired, start again").withCode(codeInvalidToken)
+	}
+	// the flow is single use whatever the outcome
+	ctx.SetCookie(&http.Cookie{Name: oidcFlowCookie, Path: oidcPathPrefix, MaxAge: -1, HttpOnly: true})
+	value, err := serv.signer.Verify(oidcFlowPurpose, cookie.Value)
+	if err != nil {
+		return newError(http.StatusBadRequest, "login has expired, start again").withCode(codeInvalidToken)
+	}
+	var flow oidcFlow
+	if err = json.Unmarshal([]byte(value), &flow); err != nil {
+		return newError(http.StatusBadRequest, "login has expired, start again").withCode(codeInvalidToken)
+	}
+	if flow.Provider != provider.Name() || subtle.ConstantTimeCompare([]byte(flow.State), []byte(req.State)) != 1 {
+		return newError(http.StatusBadRequest, "state does not match").withCode(codeInvalidToken)
+	}
+
+	if req.Error != "" {
+		return newError(http.StatusUnauthorized, "provider refused the login").with("error", req.Error)
+	}
+	if req.Code == "" {
+		return newError(http.StatusBadRequest, "code is required")
+	}
+	claims, err := provider.Exchange(ctx.Request().Context(), req.Code, flow.Verifier, flow.Nonce)
+	if err != nil {
+		log.Warn().Err(err).Str("provider", provider.Name()).Msg("cannot complete oidc login")
+		if errors.Is(err, oidc.ErrInvalidIDToken) {
+			return newError(http.StatusUnauthorized, "invalid id token").withCode(codeInvalidToken)
+		}
+		return newError(http.StatusBadGateway, "cannot complete login with provider")
+	}
+
+	user, err := serv.oidcUser(ctx.Request().Context(), provider.Name(), claims)
+	if err != nil {
+		return err
+	}
+	return serv.completeLogin(ctx, &user)
+}
+
+type (
+	oidcCallbackRequest struct {
+		Provider string `param:"provider" validate:"required"`
+		Code     string `query:"code"`
+		State    string `query:"state" validate:"required"`
+		Error    string `query:"error"`
+	}
+)
+
+// oidcUser returns the user a provider identity is linked to. An unknown identity is linked to
+// the account with its email address when both the provider and Planny have verified the
+// address, otherwise a new account is created for it.
+func (serv *Server) oidcUser(ctx context.Context, provider string, claims *oidc.Claims) (model.User, error) {
+	identity, err := serv.store.GetUserIdentity(ctx, provider, claims.Subject)
+	if err == nil {
+		user, err := serv.store.GetUserById(ctx, identity.UserID)
+		if err != nil {
+			return model.User{}, newError(http.StatusInternalServerError, "failed to get user")
+		}
+		return user, nil
+	}
+	if !errors.Is(err, db.ErrRecordNotFound) {
+		return model.User{}, newError(http.StatusInternalServerError, "failed to get identity")
+	}
+	if claims.Email == "" {
+		return model.User{}, newError(http.StatusBadRequest, "provider did not share an email address")
+	}
+
+	var user model.User
+	created := false
+	err = serv.store.ExecTx(ctx, func(store db.Store) error {
+		existing, err := store.GetUserByEmail(ctx, claims.Email)
+		switch {
+		case err == nil:
+			// whoever registers an address first could otherwise take over the provider login
+			// of its owner, so both sides have to vouch for the address
+			if !claims.EmailVerified || !existing.EmailVerified {
+				return newError(http.StatusConflict, "an account with this email address already exists, sign in with its password")
+			}
+			user = existing
+		case errors.Is(err, db.ErrRecordNotFound):
+			if user, err = createOidcUser(ctx, store, claims); err != nil {
+				return err
+			}
+			created = true
+		default:
+			return err
+		}
+
+		_, err = store.CreateUserIdentity(ctx, db.CreateUserIdentityArg{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		})
+		return err
+	})
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			return model.User{}, apiErr
+		}
+		return model.User{}, newError(http.StatusInternalServerError, "failed to link identity")
+	}
+	if created && !user.EmailVerified {
+		go serv.sendEmailVerification(user)
+	}
+	return user, nil
+}
+
+// createOidcUser creates the account of a new identity. Its password is random, students who
+// want one set it with the password reset.
+func createOidcUser(ctx context.Context, store db.Store, claims *oidc.Claims) (model.User, error) {
+	secret, err := security.GenerateRandomToken()
+	if err != nil {
+		return model.User{}, err
+	}
+	password, err := security.HashPassword(secret)
+	if err != nil {
+		return model.User{}, err
+	}
+	username, err := availableUsername(ctx, store, claims)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(claims.Name), " ")
+	}
+	if firstName == "" {
+		firstName = username
+	}
+	user, err := store.CreateUser(ctx, db.CreateUserArg{
+		Username:       username,
+		Email:          claims.Email,
+		FirstName:      truncate(firstName, maxNameSize),
+		LastName:       truncate(strings.TrimSpace(lastName), maxNameSize),
+		HashedPassword: password,
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+	if claims.EmailVerified {
+		if err = store.MarkUserEmailVerified(ctx, user.ID); err != nil {
+			return model.User{}, err
+		}
+		user.EmailVerified = true
+	}
+	return user, nil
+}
+
+// availableUsername derives a username from the claims, a random suffix is added when it is
+// taken
+func availableUsername(ctx context.Context, store db.Store, claims *oidc.Claims) (string, error) {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, name)
+	if base == "" {
+		base = "student"
+	}
+	base = truncate(base, maxUsernameSize-5)
+
+	username := base
+	for i := 0; i < 5; i++ {
+		_, err := store.GetUserByUsername(ctx, username)
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return username, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		suffix := make([]byte, 2)
+		if _, err = rand.Read(suffix); err != nil {
+			return "", err
+		}
+		username = base + "-" + hex.EncodeToString(suffix)
+	}
+	return "", fmt.Errorf("no username available for %q", base)
+}
+
+// truncate shortens s to at most n runes
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
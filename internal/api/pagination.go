@@ -0,0 +1,78 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	db "com.github/asdsec/planny/internal/store"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 500
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// planCursor is the decoded form of the opaque next_cursor, it remembers the ordering it
+// was issued for so that it cannot be replayed against another one. Expanded cursors point
+// into occurrences rather than stored plans.
+type planCursor struct {
+	SortBy     db.PlanSortField `json:"s"`
+	Descending bool             `json:"d,omitempty"`
+	Expanded   bool             `json:"e,omitempty"`
+	Time       time.Time        `json:"t,omitempty"`
+	Title      string           `json:"v,omitempty"`
+	ID         uint             `json:"i"`
+}
+
+func encodePlanCursor(cursor planCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePlanCursor(value string, sortBy db.PlanSortField, descending, expanded bool) (*db.PlanCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor planCursor
+	if err = json.Unmarshal(b, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	if cursor.SortBy != sortBy || cursor.Descending != descending || cursor.Expanded != expanded || cursor.ID == 0 {
+		return nil, errors.New("cursor does not match the requested ordering")
+	}
+	return &db.PlanCursor{Time: cursor.Time, Title: cursor.Title, ID: cursor.ID}, nil
+}
+
+// pageOccurrences orders occurrences by start and then by plan id and returns the limit of them
+// following after, more tells whether further occurrences remain. Paging over occurrences
+// rather than series keeps pages contiguous when series are expanded.
+func pageOccurrences(occurrences []model.Plan, after *db.PlanCursor, descending bool, limit int) (page []model.Plan, more bool) {
+	precedes := func(start time.Time, id uint, other time.Time, otherID uint) bool {
+		if descending {
+			start, id, other, otherID = other, otherID, start, id
+		}
+		if !start.Equal(other) {
+			return start.Before(other)
+		}
+		return id < otherID
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return precedes(occurrences[i].StartDate, occurrences[i].ID, occurrences[j].StartDate, occurrences[j].ID)
+	})
+	if after != nil {
+		first := sort.Search(len(occurrences), func(i int) bool {
+			return precedes(after.Time, after.ID, occurrences[i].StartDate, occurrences[i].ID)
+		})
+		occurrences = occurrences[first:]
+	}
+	if len(occurrences) > limit {
+		return occurrences[:limit], true
+	}
+	return occurrences, false
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/calendar"
+	"com.github/asdsec/planny/internal/model"
+	db "com.github/asdsec/planny/internal/store"
+	"testing"
+	"time"
+)
+
+// walkOccurrences pages through the expanded plans like a client following next_cursor and
+// returns every page
+func walkOccurrences(t *testing.T, plans []model.Plan, from, to time.Time, descending bool, limit int) [][]model.Plan {
+	t.Helper()
+	var pages [][]model.Plan
+	cursor := ""
+	for i := 0; ; i++ {
+		if i > 100 {
+			t.Fatal("paging does not end")
+		}
+		var after *db.PlanCursor
+		if cursor != "" {
+			var err error
+			if after, err = decodePlanCursor(cursor, db.SortByStartDate, descending, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// every request expands afresh like retrievePlans does
+		occurrences, err := calendar.ExpandAll(append([]model.Plan(nil), plans...), from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, more := pageOccurrences(occurrences, after, descending, limit)
+		pages = append(pages, page)
+		if !more {
+			return pages
+		}
+		last := page[len(page)-1]
+		cursor = encodePlanCursor(planCursor{
+			SortBy:     db.SortByStartDate,
+			Descending: descending,
+			Expanded:   true,
+			Time:       last.StartDate,
+			ID:         last.ID,
+		})
+	}
+}
+
+func TestPageOccurrences(t *testing.T) {
+	day := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	plans := []model.Plan{
+		{ID: 1, StartDate: day, EndDate: day.Add(time.Hour), RecurrenceRule: "FREQ=DAILY"},
+		{ID: 2, StartDate: day.Add(2 * time.Hour), EndDate: day.Add(3 * time.Hour)},
+		{ID: 3, StartDate: day, EndDate: day.Add(30 * time.Minute), RecurrenceRule: "FREQ=WEEKLY;BYDAY=MO,WE,FR"},
+		{ID: 4, StartDate: day.AddDate(0, 0, 3), EndDate: day.AddDate(0, 0, 3).Add(time.Hour)},
+	}
+	from, to := day, day.AddDate(0, 0, 7)
+
+	for _, descending := range []bool{false, true} {
+		all, err := calendar.ExpandAll(append([]model.Plan(nil), plans...), from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, more := pageOccurrences(all, nil, descending, len(all))
+		if more {
+			t.Fatal("a page of every occurrence has more")
+		}
+		// 7 daily, 3 weekly and 2 single occurrences
+		if len(want) != 12 {
+			t.Fatalf("expanded %d occurrences, want 12", len(want))
+		}
+
+		pages := walkOccurrences(t, plans, from, to, descending, 5)
+		if len(pages) != 3 {
+			t.Fatalf("descending=%v: walked %d pages, want 3", descending, len(pages))
+		}
+		var got []model.Plan
+		for i, page := range pages {
+			if i < len(pages)-1 && len(page) != 5 {
+				t.Errorf("descending=%v: page %d holds %d occurrences, want 5", descending, i, len(page))
+			}
+			got = append(got, page...)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("descending=%v: walked %d occurrences, want %d", descending, len(got), len(want))
+		}
+		for i := range want {
+			if got[i].ID != want[i].ID || !got[i].StartDate.Equal(want[i].StartDate) {
+				t.Errorf("descending=%v: occurrence %d = plan %d at %s, want plan %d at %s", descending, i,
+					got[i].ID, got[i].StartDate, want[i].ID, want[i].StartDate)
+			}
+			if i == 0 {
+				continue
+			}
+			previous, current := got[i-1].StartDate, got[i].StartDate
+			if descending {
+				previous, current = current, previous
+			}
+			if current.Before(previous) {
+				t.Errorf("descending=%v: occurrence %d is out of order", descending, i)
+			}
+		}
+	}
+}
+
+func TestPlanCursorIsBoundToExpansion(t *testing.T) {
+	cursor := encodePlanCursor(planCursor{SortBy: db.SortByStartDate, Expanded: true, Time: time.Now(), ID: 1})
+	if _, err := decodePlanCursor(cursor, db.SortByStartDate, false, false); err == nil {
+		t.Error("an expanded cursor was accepted for stored plans")
+	}
+	if _, err := decodePlanCursor(cursor, db.SortByStartDate, true, true); err == nil {
+		t.Error("an ascending cursor was accepted for a descending order")
+	}
+	if _, err := decodePlanCursor(cursor, db.SortByStartDate, false, true); err != nil {
+		t.Errorf("decode = %v", err)
+	}
+}
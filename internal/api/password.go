@@ -0,0 +1,150 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/mail"
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// mailTimeout bounds the background work of issuing and emailing a token
+const mailTimeout = 30 * time.Second
+
+// forgotPassword emails a password reset link. It answers the same whether or not the email
+// is registered and does the work in the background so that timing does not tell either.
+func (serv *Server) forgotPassword(ctx echo.Context) error {
+	var req forgotPasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	if err := serv.limitEmailRequests(ctx, loginKeyPasswordReset, req.Email, "too many password resets requested, try again later"); err != nil {
+		return err
+	}
+
+	go serv.sendPasswordReset(req.Email)
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+type (
+	forgotPasswordRequest struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+)
+
+func (serv *Server) sendPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+
+	user, err := serv.store.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, db.ErrRecordNotFound) {
+			log.Error().Err(err).Msg("cannot get user for password reset")
+		}
+		return
+	}
+	link, err := serv.issueTokenLink(ctx, user.ID, model.PasswordResetToken, serv.conf.PasswordResetTokenDuration, "/reset-password")
+	if err != nil {
+		log.Error().Err(err).Uint("user_id", user.ID).Msg("cannot issue password reset token")
+		return
+	}
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Planny password",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to choose a new password:\n\n%s\n\n"+
+			"The link expires in %s. If you did not ask for a new password you can ignore this email.\n",
+			user.FirstName, link, serv.conf.PasswordResetTokenDuration),
+	}
+	if err = serv.mailer.Send(ctx, msg); err != nil {
+		log.Error().Err(err).Uint("user_id", user.ID).Msg("cannot send password reset email")
+	}
+}
+
+// issueTokenLink stores a new one time token of the user and returns the client link redeeming it
+func (serv *Server) issueTokenLink(ctx context.Context, userID uint, purpose model.TokenPurpose, duration time.Duration, path string) (string, error) {
+	token, err := serv.issueOneTimeToken(ctx, userID, purpose, time.Now().Add(duration))
+	if err != nil {
+		return "", err
+	}
+	return serv.appLink(path, token), nil
+}
+
+// issueOneTimeToken stores a new one time token of the user, only its hash is kept
+func (serv *Server) issueOneTimeToken(ctx context.Context, userID uint, purpose model.TokenPurpose, expiresAt time.Time) (string, error) {
+	token, err := security.GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+	arg := db.CreateOneTimeTokenArg{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: security.HashToken(token),
+		ExpiresAt: expiresAt,
+	}
+	if _, err = serv.store.CreateOneTimeToken(ctx, arg); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// appLink returns the link to a page of the client that receives token
+func (serv *Server) appLink(path, token string) string {
+	return strings.TrimRight(serv.conf.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// resetPassword sets a new password with an emailed reset token, revokes every session and
+// discards the other reset tokens of the user
+func (serv *Server) resetPassword(ctx echo.Context) error {
+	var req resetPasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	password, err := security.HashPassword(req.Password)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to hash password")
+	}
+	err = serv.store.ExecTx(ctx.Request().Context(), func(store db.Store) error {
+		token, err := store.ConsumeOneTimeToken(ctx.Request().Context(), model.PasswordResetToken, security.HashToken(req.Token))
+		if err != nil {
+			return err
+		}
+		// links of other reset emails must not change the password again
+		if err = store.DeleteOneTimeTokens(ctx.Request().Context(), token.UserID, model.PasswordResetToken); err != nil {
+			return err
+		}
+		if err = store.UpdateUserPassword(ctx.Request().Context(), token.UserID, password); err != nil {
+			return err
+		}
+		return store.BlockSessionsByUserID(ctx.Request().Context(), token.UserID)
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) || errors.Is(err, db.ErrAlreadyUsed) {
+			return newError(http.StatusBadRequest, "reset token is invalid or expired").withCode(codeInvalidToken)
+		}
+		return newError(http.StatusInternalServerError, "failed to reset password")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type (
+	resetPasswordRequest struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=6,max=32"`
+	}
+)
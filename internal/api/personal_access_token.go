@@ -0,0 +1,195 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// personalAccessTokenPrefix tells personal access tokens apart from session tokens and makes
+// leaked ones easy to find by secret scanners
+const personalAccessTokenPrefix = "planny_pat_"
+
+// Scopes a personal access token can be granted
+const (
+	scopePlansRead  = "plans:read"
+	scopePlansWrite = "plans:write"
+	scopeTagsRead   = "tags:read"
+	scopeTagsWrite  = "tags:write"
+)
+
+var tokenScopes = []string{scopePlansRead, scopePlansWrite, scopeTagsRead, scopeTagsWrite}
+
+// scoped lets personal access tokens granted scope call route, every other authorized route
+// only accepts the access token of a session
+func (serv *Server) scoped(scope string, route *echo.Route) {
+	serv.routeScopes[route.Method+" "+route.Path] = scope
+}
+
+// authorizePersonalAccessToken checks a personal access token and the scope the route requires,
+// the returned payload stands in for the one of an access token
+func (serv *Server) authorizePersonalAccessToken(ctx echo.Context, raw string) (*security.TokenPayload, error) {
+	token, err := serv.store.GetPersonalAccessTokenByHash(ctx.Request().Context(), security.HashToken(raw))
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return nil, newError(http.StatusUnauthorized, "invalid personal access token")
+		}
+		return nil, newError(http.StatusInternalServerError, "cannot verify personal access token")
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, newError(http.StatusUnauthorized, "personal access token has expired")
+	}
+
+	scope, ok := serv.routeScopes[ctx.Request().Method+" "+ctx.Path()]
+	if !ok {
+		return nil, newError(http.StatusForbidden, "personal access tokens cannot call this endpoint").
+			withCode(codeInsufficientScope)
+	}
+	if !token.HasScope(scope) {
+		return nil, newError(http.StatusForbidden, fmt.Sprintf("personal access token lacks the %s scope", scope)).
+			withCode(codeInsufficientScope).
+			with("required_scope", scope)
+	}
+
+	if err = serv.store.TouchPersonalAccessToken(ctx.Request().Context(), token.ID); err != nil {
+		log.Error().Err(err).Uint("token_id", token.ID).Msg("cannot record personal access token use")
+	}
+	payload := &security.TokenPayload{
+		UserID:    token.UserID,
+		Username:  token.Username,
+		IssuedAt:  token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+	}
+	return payload, nil
+}
+
+func (serv *Server) retrievePersonalAccessTokens(ctx echo.Context) error {
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	tokens, err := serv.store.ListPersonalAccessTokensByUserID(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve personal access tokens")
+	}
+
+	rsp := retrievePersonalAccessTokensResponse{Tokens: make([]personalAccessTokenModel, 0, len(tokens))}
+	for _, token := range tokens {
+		rsp.Tokens = append(rsp.Tokens, *personalAccessTokenResponse(&token))
+	}
+	return ctx.JSON(http.StatusOK, rsp)
+}
+
+type (
+	personalAccessTokenModel struct {
+		ID         uint       `json:"id"`
+		Name       string     `json:"name"`
+		Scopes     []string   `json:"scopes"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		ExpiresAt  time.Time  `json:"expires_at"`
+		CreatedAt  time.Time  `json:"created_at"`
+		// Token is only sent once, in the response creating it
+		Token string `json:"token,omitempty"`
+	}
+
+	retrievePersonalAccessTokensResponse struct {
+		Tokens []personalAccessTokenModel `json:"tokens"`
+	}
+)
+
+func personalAccessTokenResponse(token *model.PersonalAccessToken) *personalAccessTokenModel {
+	rsp := &personalAccessTokenModel{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		ExpiresAt: token.ExpiresAt,
+		CreatedAt: token.CreatedAt,
+	}
+	if !token.LastUsedAt.IsZero() {
+		rsp.LastUsedAt = &token.LastUsedAt
+	}
+	return rsp
+}
+
+func (serv *Server) createPersonalAccessToken(ctx echo.Context) error {
+	var req createPersonalAccessTokenRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	secret, err := security.GenerateRandomToken()
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to generate token")
+	}
+	raw := personalAccessTokenPrefix + secret
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	arg := db.CreatePersonalAccessTokenArg{
+		UserID:    payload.UserID,
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: security.HashToken(raw),
+		Scopes:    uniqueScopes(req.Scopes),
+		ExpiresAt: time.Now().AddDate(0, 0, req.ExpiresInDays),
+	}
+	token, err := serv.store.CreatePersonalAccessToken(ctx.Request().Context(), arg)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to create personal access token")
+	}
+
+	rsp := personalAccessTokenResponse(&token)
+	rsp.Token = raw
+	return ctx.JSON(http.StatusCreated, rsp)
+}
+
+type (
+	createPersonalAccessTokenRequest struct {
+		Name          string   `json:"name" validate:"required,max=64"`
+		Scopes        []string `json:"scopes" validate:"required,min=1,dive,token_scope"`
+		ExpiresInDays int      `json:"expires_in_days" validate:"required,min=1,max=365"`
+	}
+)
+
+func uniqueScopes(scopes []string) []string {
+	unique := make([]string, 0, len(scopes))
+	for _, scope := range tokenScopes {
+		for _, requested := range scopes {
+			if requested == scope {
+				unique = append(unique, scope)
+				break
+			}
+		}
+	}
+	return unique
+}
+
+func (serv *Server) deletePersonalAccessToken(ctx echo.Context) error {
+	var req deletePersonalAccessTokenRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	err := serv.store.DeletePersonalAccessToken(ctx.Request().Context(), payload.UserID, req.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "personal access token not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot revoke personal access token")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type (
+	deletePersonalAccessTokenRequest struct {
+		ID uint `param:"id" validate:"required"`
+	}
+)
@@ -1,119 +1,377 @@
 package api
 
 import (
+	"bytes"
+	"com.github/asdsec/planny/internal/calendar"
 	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/patch"
 	"com.github/asdsec/planny/internal/security"
 	db "com.github/asdsec/planny/internal/store"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
+	"io"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// Modes of the tag filter of GET /plans
+const (
+	tagModeAny = "any"
+	tagModeAll = "all"
+)
+
+// Media types accepted by PATCH /plans/:id
+const (
+	mimeMergePatch = "application/merge-patch+json"
+	mimeJSONPatch  = "application/json-patch+json"
+)
+
+// maxPatchSize is the largest body PATCH /plans/:id reads, a plan is far smaller
+const maxPatchSize = 64 << 10
+
+// Scopes of an edit or cancellation on a recurring plan
+const (
+	scopeThis      = "this"
+	scopeFollowing = "following"
+	scopeAll       = "all"
+)
+
 func (serv *Server) createPlan(ctx echo.Context) error {
 	var req createPlanRequest
 	if err := ctx.Bind(&req); err != nil {
-		return serv.err(ctx, http.StatusBadRequest, "cannot bind request body")
+		return newError(http.StatusBadRequest, "cannot bind request body")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+	if err := echo.QueryParamsBinder(ctx).Int("suggest_slots", &req.SuggestSlots).BindError(); err != nil {
+		return newError(http.StatusBadRequest, "invalid suggest_slots parameter")
+	}
+	if req.RecurrenceRule != "" {
+		rule, err := calendar.ParseRule(req.RecurrenceRule)
+		if err != nil {
+			return newError(http.StatusBadRequest, err.Error())
+		}
+		req.RecurrenceRule = rule.String()
+	}
+	tags, err := normalizeTagNames(req.Tags)
+	if err != nil {
+		return newError(http.StatusBadRequest, err.Error())
 	}
 
 	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
-	overlap, err := serv.checkPlanDateOverlap(ctx, payload.UserID, req.StartDate, req.EndDate)
+	candidate := model.Plan{
+		StartDate:      req.StartDate,
+		EndDate:        req.EndDate,
+		RecurrenceRule: req.RecurrenceRule,
+		ExDates:        req.ExDates,
+	}
+	overlap, err := serv.checkPlanDateOverlap(ctx, payload.UserID, candidate, nil)
 	if err != nil {
-		if err.Error() == db.ErrRecordNotFound {
-			return serv.err(ctx, http.StatusNotFound, "plans not found")
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plans not found")
 		}
-		return serv.err(ctx, http.StatusInternalServerError, "cannot check plan date overlap")
+		return newError(http.StatusInternalServerError, "cannot check plan date overlap")
 	}
 	if overlap {
-		return serv.err(ctx, http.StatusBadRequest, "plan date overlap")
+		if req.SuggestSlots > 0 {
+			return serv.planOverlapWithSuggestions(ctx, payload.UserID, candidate, req.SuggestSlots)
+		}
+		return newError(http.StatusBadRequest, "plan date overlap").withCode(codePlanOverlap)
 	}
 
 	arg := db.CreatePlanArg{
-		Title:       req.Title,
-		Description: req.Description,
-		StartDate:   req.StartDate,
-		EndDate:     req.EndDate,
-		Status:      db.Status(req.Status),
-		UserID:      payload.UserID,
+		Title:          req.Title,
+		Description:    req.Description,
+		StartDate:      req.StartDate,
+		EndDate:        req.EndDate,
+		Status:         db.Status(req.Status),
+		RecurrenceRule: req.RecurrenceRule,
+		ExDates:        req.ExDates,
+		AutoComplete:   req.AutoComplete,
+		Tags:           tags,
+		UserID:         payload.UserID,
 	}
 	plan, err := serv.store.CreatePlan(ctx.Request().Context(), arg)
 	if err != nil {
-		return serv.err(ctx, http.StatusInternalServerError, "cannot create plan")
+		return newError(http.StatusInternalServerError, "cannot create plan")
 	}
 
+	setPlanETag(ctx, &plan)
 	return ctx.JSON(http.StatusCreated, planResponse(&plan))
 }
 
 type (
 	createPlanRequest struct {
-		Title       string       `json:"title"  validate:"required"`
-		Description string       `json:"description" validate:"required"`
-		StartDate   time.Time    `json:"start_date" validate:"required"`
-		EndDate     time.Time    `json:"end_date" validate:"required"`
-		Status      model.Status `json:"status" validate:"required"`
+		Title          string       `json:"title" validate:"required"`
+		Description    string       `json:"description" validate:"required"`
+		StartDate      time.Time    `json:"start_date" validate:"required"`
+		EndDate        time.Time    `json:"end_date" validate:"required,gtfield=StartDate"`
+		Status         model.Status `json:"status" validate:"required,plan_status"`
+		RecurrenceRule string       `json:"recurrence_rule"`
+		ExDates        []time.Time  `json:"exdates"`
+		AutoComplete   bool         `json:"auto_complete"`
+		Tags           []string     `json:"tags"`
+		SuggestSlots   int          `json:"-"`
 	}
 
 	createPlanResponse struct {
-		ID          uint         `json:"id"`
-		Title       string       `json:"title"`
-		Description string       `json:"description"`
-		StartDate   time.Time    `json:"start_date"`
-		EndDate     time.Time    `json:"end_date"`
-		Status      model.Status `json:"status"`
-		UserID      uint         `json:"user_id"`
-		CreatedAt   time.Time    `json:"created_at"`
-		UpdatedAt   time.Time    `json:"updated_at"`
+		ID             uint         `json:"id"`
+		Title          string       `json:"title"`
+		Description    string       `json:"description"`
+		StartDate      time.Time    `json:"start_date"`
+		EndDate        time.Time    `json:"end_date"`
+		Status         model.Status `json:"status"`
+		RecurrenceRule string       `json:"recurrence_rule,omitempty"`
+		ExDates        []time.Time  `json:"exdates,omitempty"`
+		ParentID       uint         `json:"parent_id,omitempty"`
+		RecurrenceID   *time.Time   `json:"recurrence_id,omitempty"`
+		AutoComplete   bool         `json:"auto_complete"`
+		ItemCount      int          `json:"item_count"`
+		DoneItemCount  int          `json:"done_item_count"`
+		Progress       int          `json:"progress"`
+		Tags           []string     `json:"tags"`
+		Version        uint         `json:"version"`
+		UserID         uint         `json:"user_id"`
+		CreatedAt      time.Time    `json:"created_at"`
+		UpdatedAt      time.Time    `json:"updated_at"`
 	}
 )
 
+// planOverlapWithSuggestions rejects an overlapping plan and lists the nearest free slots it would fit in
+func (serv *Server) planOverlapWithSuggestions(ctx echo.Context, userID uint, candidate model.Plan, limit int) error {
+	plans, err := serv.store.ListPlansByUserID(ctx.Request().Context(), userID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve plans")
+	}
+	slots, err := suggestFreeSlots(candidate, plans, limit)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot compute free slots")
+	}
+	return newError(http.StatusBadRequest, "plan date overlap").withCode(codePlanOverlap).with("free_slots", slots)
+}
+
 func planResponse(plan *model.Plan) *createPlanResponse {
-	return &createPlanResponse{
-		ID:          plan.ID,
-		Title:       plan.Title,
-		Description: plan.Description,
-		StartDate:   plan.StartDate,
-		EndDate:     plan.EndDate,
-		Status:      plan.Status,
-		UserID:      plan.UserID,
-		CreatedAt:   plan.CreatedAt,
-		UpdatedAt:   plan.UpdatedAt,
+	res := &createPlanResponse{
+		ID:             plan.ID,
+		Title:          plan.Title,
+		Description:    plan.Description,
+		StartDate:      plan.StartDate,
+		EndDate:        plan.EndDate,
+		Status:         plan.Status,
+		RecurrenceRule: plan.RecurrenceRule,
+		ExDates:        plan.ExDates,
+		ParentID:       plan.ParentID,
+		AutoComplete:   plan.AutoComplete,
+		ItemCount:      plan.ItemCount,
+		DoneItemCount:  plan.DoneItemCount,
+		Progress:       plan.Progress(),
+		Tags:           tagNames(plan.Tags),
+		Version:        plan.Version,
+		UserID:         plan.UserID,
+		CreatedAt:      plan.CreatedAt,
+		UpdatedAt:      plan.UpdatedAt,
 	}
+	if !plan.RecurrenceID.IsZero() {
+		recurrenceID := plan.RecurrenceID
+		res.RecurrenceID = &recurrenceID
+	}
+	return res
+}
+
+func tagNames(tags []model.Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return names
 }
 
 func (serv *Server) retrievePlans(ctx echo.Context) error {
+	var req retrievePlansRequest
+	err := echo.QueryParamsBinder(ctx).
+		Time("from", &req.From, time.RFC3339).
+		Time("to", &req.To, time.RFC3339).
+		Strings("status", &req.Statuses).
+		String("q", &req.Search).
+		Strings("tags", &req.Tags).
+		String("tag_mode", &req.TagMode).
+		String("sort", &req.Sort).
+		String("order", &req.Order).
+		String("cursor", &req.Cursor).
+		Int("limit", &req.Limit).
+		BindError()
+	if err != nil {
+		return newError(http.StatusBadRequest, "invalid query parameters")
+	}
+	// series are expanded over the window, which needs both of its bounds
+	switch {
+	case req.From.IsZero() && !req.To.IsZero():
+		return newError(http.StatusBadRequest, "from is required when to is given")
+	case !req.From.IsZero() && req.To.IsZero():
+		req.To = req.From.Add(calendar.Horizon)
+	case !req.To.After(req.From) && !req.From.IsZero():
+		return newError(http.StatusBadRequest, "from and to must form a valid window")
+	}
+	req.Tags, err = normalizeTagNames(splitQueryList(req.Tags))
+	if err != nil {
+		return newError(http.StatusBadRequest, err.Error())
+	}
+	if req.TagMode != "" && req.TagMode != tagModeAny && req.TagMode != tagModeAll {
+		return newError(http.StatusBadRequest, "tag_mode must be any or all")
+	}
+	var statuses []db.Status
+	for _, status := range splitQueryList(req.Statuses) {
+		switch model.Status(status) {
+		case model.Done, model.InProgress, model.Cancelled:
+			statuses = append(statuses, db.Status(status))
+		default:
+			return newError(http.StatusBadRequest, "unknown status "+status)
+		}
+	}
+	sortBy := db.PlanSortField(req.Sort)
+	switch sortBy {
+	case "":
+		sortBy = db.SortByStartDate
+	case db.SortByStartDate, db.SortByEndDate, db.SortByCreatedAt, db.SortByUpdatedAt, db.SortByTitle:
+	default:
+		return newError(http.StatusBadRequest, "unknown sort field "+req.Sort)
+	}
+	if req.Order != "" && req.Order != "asc" && req.Order != "desc" {
+		return newError(http.StatusBadRequest, "order must be asc or desc")
+	}
+	descending := req.Order == "desc"
+	if req.Limit <= 0 || req.Limit > maxPageSize {
+		req.Limit = defaultPageSize
+	}
+	// with a window the page holds occurrences, which only have a start of their own
+	expand := !req.From.IsZero()
+	if expand && sortBy != db.SortByStartDate {
+		return newError(http.StatusBadRequest, "plans within from and to can only be sorted by start_date")
+	}
+
 	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
-	plans, err := serv.store.ListPlansByUserID(ctx.Request().Context(), payload.UserID)
+	arg := db.ListPlansArg{
+		UserID:       payload.UserID,
+		From:         req.From,
+		To:           req.To,
+		Statuses:     statuses,
+		Search:       strings.TrimSpace(req.Search),
+		Tags:         req.Tags,
+		MatchAllTags: req.TagMode == tagModeAll,
+		SortBy:       sortBy,
+		Descending:   descending,
+		// one more plan than requested tells whether there is a next page
+		Limit: req.Limit + 1,
+	}
+	var after *db.PlanCursor
+	if req.Cursor != "" {
+		after, err = decodePlanCursor(req.Cursor, sortBy, descending, expand)
+		if err != nil {
+			return newError(http.StatusBadRequest, err.Error())
+		}
+	}
+	if expand {
+		// every series of the window is expanded, the occurrences are paged afterwards
+		arg.Limit = 0
+	} else {
+		arg.After = after
+	}
+	plans, err := serv.store.ListPlans(ctx.Request().Context(), arg)
 	if err != nil {
-		if err.Error() == db.ErrRecordNotFound {
-			return serv.err(ctx, http.StatusNotFound, "plans not found")
+		return newError(http.StatusInternalServerError, "cannot retrieve plans")
+	}
+
+	var nextCursor string
+	if expand {
+		plans, err = calendar.ExpandAll(plans, req.From, req.To)
+		if err != nil {
+			return newError(http.StatusInternalServerError, "cannot expand recurring plans")
+		}
+		var more bool
+		plans, more = pageOccurrences(plans, after, descending, req.Limit)
+		if more {
+			last := plans[len(plans)-1]
+			nextCursor = encodePlanCursor(planCursor{
+				SortBy:     sortBy,
+				Descending: descending,
+				Expanded:   true,
+				Time:       last.StartDate,
+				ID:         last.ID,
+			})
+		}
+	} else if len(plans) > req.Limit {
+		plans = plans[:req.Limit]
+		last := plans[len(plans)-1]
+		cursor := planCursor{SortBy: sortBy, Descending: descending, ID: last.ID}
+		switch sortBy {
+		case db.SortByTitle:
+			cursor.Title = last.Title
+		case db.SortByEndDate:
+			cursor.Time = last.EndDate
+		case db.SortByCreatedAt:
+			cursor.Time = last.CreatedAt
+		case db.SortByUpdatedAt:
+			cursor.Time = last.UpdatedAt
+		default:
+			cursor.Time = last.StartDate
 		}
-		return serv.err(ctx, http.StatusInternalServerError, "cannot retrieve plans")
+		nextCursor = encodePlanCursor(cursor)
 	}
 
-	return ctx.JSON(http.StatusOK, newRetrievePlansResponse(&plans))
+	rsp := newRetrievePlansResponse(&plans)
+	rsp.NextCursor = nextCursor
+	return ctx.JSON(http.StatusOK, rsp)
 }
 
 type (
+	// retrievePlansRequest pages over stored plans, or over their occurrences when a window is
+	// given
+	retrievePlansRequest struct {
+		From     time.Time
+		To       time.Time
+		Statuses []string
+		Search   string
+		Tags     []string
+		TagMode  string
+		Sort     string
+		Order    string
+		Cursor   string
+		Limit    int
+	}
+
 	retrievePlanModel createPlanResponse
 
 	retrievePlansResponse struct {
-		Plans []retrievePlanModel `json:"plans"`
+		Plans      []retrievePlanModel `json:"plans"`
+		NextCursor string              `json:"next_cursor,omitempty"`
 	}
 )
 
+// splitQueryList accepts both repeated and comma separated query values
+func splitQueryList(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
 func newRetrievePlansResponse(plans *[]model.Plan) *retrievePlansResponse {
 	var res retrievePlansResponse
 	for _, plan := range *plans {
-		res.Plans = append(res.Plans, retrievePlanModel{
-			ID:          plan.ID,
-			Title:       plan.Title,
-			Description: plan.Description,
-			StartDate:   plan.StartDate,
-			EndDate:     plan.EndDate,
-			Status:      plan.Status,
-			UserID:      plan.UserID,
-			CreatedAt:   plan.CreatedAt,
-			UpdatedAt:   plan.UpdatedAt,
-		})
+		res.Plans = append(res.Plans, retrievePlanModel(*planResponse(&plan)))
 	}
 	if len(res.Plans) == 0 {
 		res.Plans = []retrievePlanModel{}
@@ -121,30 +379,124 @@ func newRetrievePlansResponse(plans *[]model.Plan) *retrievePlansResponse {
 	return &res
 }
 
+func (serv *Server) retrievePlan(ctx echo.Context) error {
+	var req retrievePlanRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	plan, err := serv.store.GetPlanByID(ctx.Request().Context(), req.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve plan")
+	}
+	// plans of other users are reported as missing so that ids cannot be enumerated
+	if plan.UserID != payload.UserID {
+		return newError(http.StatusNotFound, "plan not found")
+	}
+
+	ctx.Response().Header().Set(echo.HeaderCacheControl, "private, no-cache")
+	ctx.Response().Header().Set("Accept-Patch", mimeMergePatch+", "+mimeJSONPatch)
+	setPlanETag(ctx, &plan)
+	if match := ctx.Request().Header.Get("If-None-Match"); match != "" && etagMatchesWeak(match, planETag(&plan)) {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+
+	return ctx.JSON(http.StatusOK, planResponse(&plan))
+}
+
+type (
+	retrievePlanRequest struct {
+		ID uint `param:"id" validate:"required"`
+	}
+)
+
 func (serv *Server) deletePlan(ctx echo.Context) error {
 	var req deletePlanRequest
 	if err := ctx.Bind(&req); err != nil {
-		return serv.err(ctx, http.StatusBadRequest, "cannot bind request body")
+		return newError(http.StatusBadRequest, "cannot bind request body")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+	var query occurrenceQuery
+	if err := bindOccurrenceQuery(ctx, &query); err != nil {
+		return newError(http.StatusBadRequest, err.Error())
 	}
 
 	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
 	plan, err := serv.store.GetPlanByID(ctx.Request().Context(), req.ID)
 	if err != nil {
-		if err.Error() == db.ErrRecordNotFound {
-			return serv.err(ctx, http.StatusNotFound, "plan not found")
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
 		}
-		return serv.err(ctx, http.StatusInternalServerError, "cannot retrieve plan")
+		return newError(http.StatusInternalServerError, "cannot retrieve plan")
 	}
 	if plan.UserID != payload.UserID {
-		return serv.err(ctx, http.StatusForbidden, "plan does not belong to user")
+		return newError(http.StatusForbidden, "plan does not belong to user")
+	}
+	version, ok := ifMatchVersion(ctx, &plan)
+	if !ok {
+		return newError(http.StatusPreconditionFailed, "plan has been modified").withCode(codeVersionConflict)
+	}
+
+	if query.Scope != scopeAll {
+		if !plan.IsRecurring() || !calendar.IsOccurrence(plan, query.Occurrence) {
+			return newError(http.StatusBadRequest, "occurrence does not belong to plan")
+		}
+		if query.Scope == scopeThis {
+			arg := db.UpdatePlanArg{
+				ID:      plan.ID,
+				ExDates: append(plan.ExDates, query.Occurrence),
+				Version: version,
+			}
+			_, err = serv.store.UpdatePlanByID(ctx.Request().Context(), arg)
+			if err != nil {
+				if errors.Is(err, db.ErrVersionConflict) {
+					return newError(http.StatusPreconditionFailed, "plan has been modified").withCode(codeVersionConflict)
+				}
+				return newError(http.StatusInternalServerError, "cannot cancel plan occurrence")
+			}
+			return ctx.NoContent(http.StatusNoContent)
+		}
+		if !query.Occurrence.Equal(plan.StartDate) {
+			err = serv.store.ExecTx(ctx.Request().Context(), func(store db.Store) error {
+				arg, err := truncatePlanSeries(plan, query.Occurrence)
+				if err != nil {
+					return err
+				}
+				arg.Version = version
+				_, err = store.UpdatePlanByID(ctx.Request().Context(), arg)
+				if err != nil {
+					return err
+				}
+				return store.DeletePlanExceptions(ctx.Request().Context(), plan.ID, query.Occurrence)
+			})
+			if err != nil {
+				if errors.Is(err, db.ErrVersionConflict) {
+					return newError(http.StatusPreconditionFailed, "plan has been modified").withCode(codeVersionConflict)
+				}
+				return newError(http.StatusInternalServerError, "cannot cancel following plan occurrences")
+			}
+			return ctx.NoContent(http.StatusNoContent)
+		}
 	}
 
-	err = serv.store.DeletePlanByID(ctx.Request().Context(), req.ID)
+	err = serv.store.DeletePlanByID(ctx.Request().Context(), db.DeletePlanArg{ID: req.ID, Version: version})
 	if err != nil {
-		if err.Error() == db.ErrRecordNotFound {
-			return serv.err(ctx, http.StatusNotFound, "plan not found")
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
 		}
-		return serv.err(ctx, http.StatusInternalServerError, "cannot delete plan")
+		if errors.Is(err, db.ErrVersionConflict) {
+			return newError(http.StatusPreconditionFailed, "plan has been modified").withCode(codeVersionConflict)
+		}
+		return newError(http.StatusInternalServerError, "cannot delete plan")
 	}
 
 	return ctx.NoContent(http.StatusNoContent)
@@ -154,79 +506,512 @@ func (serv *Server) deletePlan(ctx echo.Context) error {
 	deletePlanRequest struct {
 		ID uint `param:"id" validate:"required"`
 	}
+
+	// occurrenceQuery selects which occurrences of a recurring plan an edit applies to
+	occurrenceQuery struct {
+		Scope      string
+		Occurrence time.Time
+	}
 )
 
+func bindOccurrenceQuery(ctx echo.Context, query *occurrenceQuery) error {
+	err := echo.QueryParamsBinder(ctx).
+		String("scope", &query.Scope).
+		Time("occurrence", &query.Occurrence, time.RFC3339).
+		BindError()
+	if err != nil {
+		return errors.New("invalid occurrence query")
+	}
+	switch query.Scope {
+	case "":
+		query.Scope = scopeAll
+	case scopeAll:
+	case scopeThis, scopeFollowing:
+		if query.Occurrence.IsZero() {
+			return fmt.Errorf("occurrence is required for scope %s", query.Scope)
+		}
+	default:
+		return fmt.Errorf("unknown scope %s", query.Scope)
+	}
+	return nil
+}
+
 func (serv *Server) updatePlan(ctx echo.Context) error {
 	var req updatePlanRequest
-	if err := ctx.Bind(&req); err != nil {
-		return serv.err(ctx, http.StatusBadRequest, "cannot bind request body")
+	if err := echo.PathParamsBinder(ctx).MustUint("id", &req.ID).BindError(); err != nil {
+		return newError(http.StatusBadRequest, "invalid plan id")
+	}
+	mediaType, _, _ := mime.ParseMediaType(ctx.Request().Header.Get(echo.HeaderContentType))
+	switch mediaType {
+	case mimeJSONPatch:
+		req.JSONPatch = true
+	case "", mimeMergePatch, echo.MIMEApplicationJSON:
+	default:
+		return newError(http.StatusUnsupportedMediaType, "body must be a JSON Merge Patch or a JSON Patch")
+	}
+	var err error
+	body := http.MaxBytesReader(ctx.Response(), ctx.Request().Body, maxPatchSize)
+	if req.Patch, err = io.ReadAll(body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return newError(http.StatusRequestEntityTooLarge, "request body is too large")
+		}
+		return newError(http.StatusBadRequest, "cannot read request body")
+	}
+	var query occurrenceQuery
+	if err = bindOccurrenceQuery(ctx, &query); err != nil {
+		return newError(http.StatusBadRequest, err.Error())
 	}
 
 	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
 	plan, err := serv.store.GetPlanByID(ctx.Request().Context(), req.ID)
 	if err != nil {
-		if err.Error() == db.ErrRecordNotFound {
-			return serv.err(ctx, http.StatusNotFound, "plan not found")
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
 		}
-		return serv.err(ctx, http.StatusInternalServerError, "cannot retrieve plan")
+		return newError(http.StatusInternalServerError, "cannot retrieve plan")
 	}
 	if plan.UserID != payload.UserID {
-		return serv.err(ctx, http.StatusForbidden, "plan does not belong to user")
+		return newError(http.StatusForbidden, "plan does not belong to user")
+	}
+	version, ok := ifMatchVersion(ctx, &plan)
+	if !ok {
+		return newError(http.StatusPreconditionFailed, "plan has been modified").withCode(codeVersionConflict)
 	}
 
-	overlap, err := serv.checkPlanDateOverlap(ctx, payload.UserID, req.StartDate, req.EndDate)
+	if query.Scope != scopeAll {
+		if !plan.IsRecurring() || !calendar.IsOccurrence(plan, query.Occurrence) {
+			return newError(http.StatusBadRequest, "occurrence does not belong to plan")
+		}
+		if query.Scope == scopeThis {
+			return serv.updatePlanOccurrence(ctx, plan, version, query.Occurrence, &req)
+		}
+		if !query.Occurrence.Equal(plan.StartDate) {
+			return serv.updateFollowingPlanOccurrences(ctx, plan, version, query.Occurrence, &req)
+		}
+	}
+
+	candidate, err := req.applyTo(plan)
+	if err != nil {
+		return patchError(err)
+	}
+	if plan.ParentID != 0 && (candidate.IsRecurring() || len(candidate.ExDates) > 0) {
+		return newError(http.StatusBadRequest, "recurrence cannot be set on a single occurrence")
+	}
+	// exdates and detached occurrences are keyed by the start of the occurrence they replace,
+	// so moving the series moves them along
+	var shift time.Duration
+	if plan.IsRecurring() && candidate.IsRecurring() && !candidate.StartDate.Equal(plan.StartDate) {
+		shift = candidate.StartDate.Sub(plan.StartDate)
+		if equalTimes(candidate.ExDates, plan.ExDates) {
+			candidate.ExDates = shiftTimes(plan.ExDates, shift)
+		}
+	}
+	overlap, err := serv.checkPlanDateOverlap(ctx, payload.UserID, candidate, nil)
 	if err != nil {
-		if err.Error() == db.ErrRecordNotFound {
-			return serv.err(ctx, http.StatusNotFound, "plans not found")
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plans not found")
 		}
-		return serv.err(ctx, http.StatusInternalServerError, "cannot check plan date overlap")
+		return newError(http.StatusInternalServerError, "cannot check plan date overlap")
 	}
 	if overlap {
-		return serv.err(ctx, http.StatusBadRequest, "plan date overlap")
+		return newError(http.StatusBadRequest, "plan date overlap").withCode(codePlanOverlap)
 	}
 
-	arg := db.UpdatePlanArg{
-		ID:          req.ID,
-		Title:       req.Title,
-		Description: req.Description,
-		StartDate:   req.StartDate,
-		EndDate:     req.EndDate,
-		Status:      db.Status(req.Status),
-	}
-	plan, err = serv.store.UpdatePlanByID(ctx.Request().Context(), arg)
+	arg := planUpdate(plan, candidate)
+	arg.Version = version
+	err = serv.store.ExecTx(ctx.Request().Context(), func(store db.Store) error {
+		updated, err := store.UpdatePlanByID(ctx.Request().Context(), arg)
+		if err != nil {
+			return err
+		}
+		plan = updated
+		if shift == 0 {
+			return nil
+		}
+		return store.ShiftPlanExceptions(ctx.Request().Context(), plan.ID, shift)
+	})
 	if err != nil {
-		if err.Error() == db.ErrRecordNotFound {
-			return serv.err(ctx, http.StatusNotFound, "plan not found")
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
 		}
-		return serv.err(ctx, http.StatusInternalServerError, "cannot update plan")
+		if errors.Is(err, db.ErrVersionConflict) {
+			return newError(http.StatusPreconditionFailed, "plan has been modified").withCode(codeVersionConflict)
+		}
+		return newError(http.StatusInternalServerError, "cannot update plan")
 	}
 
+	setPlanETag(ctx, &plan)
 	return ctx.JSON(http.StatusOK, planResponse(&plan))
 }
 
 type (
+	// updatePlanRequest carries the patch document of PATCH /plans/:id, a JSON Merge Patch
+	// unless JSONPatch is set
 	updatePlanRequest struct {
-		ID          uint         `param:"id" validate:"required"`
-		Title       string       `json:"title"`
-		Description string       `json:"description"`
-		StartDate   time.Time    `json:"start_date"`
-		EndDate     time.Time    `json:"end_date"`
-		Status      model.Status `json:"status"`
+		ID        uint
+		JSONPatch bool
+		Patch     []byte
+	}
+
+	// planDocument is the representation of a plan that patches are applied to
+	planDocument struct {
+		Title          string       `json:"title" validate:"required"`
+		Description    string       `json:"description"`
+		StartDate      time.Time    `json:"start_date" validate:"required"`
+		EndDate        time.Time    `json:"end_date" validate:"required,gtfield=StartDate"`
+		Status         model.Status `json:"status" validate:"required,plan_status"`
+		RecurrenceRule string       `json:"recurrence_rule"`
+		ExDates        []time.Time  `json:"exdates"`
+		AutoComplete   bool         `json:"auto_complete"`
+		Tags           []string     `json:"tags"`
 	}
 )
 
-func (serv *Server) checkPlanDateOverlap(ctx echo.Context, userID uint, startDate, endDate time.Time) (bool, error) {
+// applyTo returns plan with the patch applied. Fields missing from the patch are kept, explicit
+// nulls and empty values clear them, and the merged plan is validated as a whole.
+func (req *updatePlanRequest) applyTo(plan model.Plan) (model.Plan, error) {
+	doc, err := json.Marshal(planDocument{
+		Title:          plan.Title,
+		Description:    plan.Description,
+		StartDate:      plan.StartDate,
+		EndDate:        plan.EndDate,
+		Status:         plan.Status,
+		RecurrenceRule: plan.RecurrenceRule,
+		ExDates:        plan.ExDates,
+		AutoComplete:   plan.AutoComplete,
+		Tags:           tagNames(plan.Tags),
+	})
+	if err != nil {
+		return plan, err
+	}
+	if req.JSONPatch {
+		doc, err = patch.JSONPatch(doc, req.Patch)
+	} else {
+		doc, err = patch.MergePatch(doc, req.Patch)
+	}
+	if err != nil {
+		return plan, err
+	}
+
+	var merged planDocument
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&merged); err != nil {
+		return plan, fmt.Errorf("%w: %s", patch.ErrInvalidPatch, err)
+	}
+	if err = merged.validate(); err != nil {
+		return plan, err
+	}
+
+	plan.Title = merged.Title
+	plan.Description = merged.Description
+	plan.StartDate = merged.StartDate
+	plan.EndDate = merged.EndDate
+	plan.Status = merged.Status
+	plan.RecurrenceRule = merged.RecurrenceRule
+	plan.ExDates = merged.ExDates
+	plan.AutoComplete = merged.AutoComplete
+	plan.Tags = make([]model.Tag, 0, len(merged.Tags))
+	for _, name := range merged.Tags {
+		plan.Tags = append(plan.Tags, model.Tag{Name: name, UserID: plan.UserID})
+	}
+	return plan, nil
+}
+
+// validate checks a patched plan and normalizes its recurrence rule and tags
+func (doc *planDocument) validate() error {
+	if err := defaultValidator.Validate(doc); err != nil {
+		return err
+	}
+	if doc.RecurrenceRule != "" {
+		rule, err := calendar.ParseRule(doc.RecurrenceRule)
+		if err != nil {
+			return err
+		}
+		doc.RecurrenceRule = rule.String()
+	}
+	tags, err := normalizeTagNames(doc.Tags)
+	if err != nil {
+		return err
+	}
+	doc.Tags = tags
+	return nil
+}
+
+// patchError reports a patch that cannot be applied, a failed JSON Patch test is a conflict
+// with the current state of the plan
+func patchError(err error) error {
+	if errors.Is(err, patch.ErrTestFailed) {
+		return newError(http.StatusConflict, err.Error()).withCode(codePatchTestFailed)
+	}
+	return validationError(err)
+}
+
+// planUpdate returns the update turning original into patched, unchanged fields are left out
+func planUpdate(original, patched model.Plan) db.UpdatePlanArg {
+	arg := db.UpdatePlanArg{ID: original.ID}
+	if patched.Title != original.Title {
+		arg.Title = &patched.Title
+	}
+	if patched.Description != original.Description {
+		arg.Description = &patched.Description
+	}
+	if !patched.StartDate.Equal(original.StartDate) {
+		arg.StartDate = &patched.StartDate
+	}
+	if !patched.EndDate.Equal(original.EndDate) {
+		arg.EndDate = &patched.EndDate
+	}
+	if patched.Status != original.Status {
+		status := db.Status(patched.Status)
+		arg.Status = &status
+	}
+	if patched.RecurrenceRule != original.RecurrenceRule {
+		arg.RecurrenceRule = &patched.RecurrenceRule
+	}
+	if !equalTimes(patched.ExDates, original.ExDates) {
+		arg.ExDates = append([]time.Time{}, patched.ExDates...)
+	}
+	if patched.AutoComplete != original.AutoComplete {
+		arg.AutoComplete = &patched.AutoComplete
+	}
+	if names := tagNames(patched.Tags); !sameTagNames(names, tagNames(original.Tags)) {
+		arg.Tags = names
+	}
+	return arg
+}
+
+func shiftTimes(times []time.Time, d time.Duration) []time.Time {
+	shifted := make([]time.Time, len(times))
+	for i, t := range times {
+		shifted[i] = t.Add(d)
+	}
+	return shifted
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameTagNames reports whether a and b name the same tags, the order of tags carries no meaning
+func sameTagNames(a, b []string) bool {
+	names := make(map[string]bool, len(a))
+	for _, name := range a {
+		names[name] = true
+	}
+	for _, name := range b {
+		if !names[name] {
+			return false
+		}
+		delete(names, name)
+	}
+	return len(names) == 0
+}
+
+// updatePlanOccurrence detaches a single occurrence from its series and stores it as its own plan,
+// a non-zero version guards the write to the series
+func (serv *Server) updatePlanOccurrence(ctx echo.Context, series model.Plan, version uint, occurrence time.Time, req *updatePlanRequest) error {
+	detached := series
+	detached.ID = 0
+	detached.StartDate = occurrence
+	detached.EndDate = occurrence.Add(series.EndDate.Sub(series.StartDate))
+	detached.RecurrenceRule = ""
+	detached.ExDates = nil
+	detached, err := req.applyTo(detached)
+	if err != nil {
+		return patchError(err)
+	}
+	if detached.IsRecurring() || len(detached.ExDates) > 0 {
+		return newError(http.StatusBadRequest, "recurrence cannot be set on a single occurrence")
+	}
+
+	skip := func(existing model.Plan) bool {
+		return existing.ID == series.ID && existing.RecurrenceID.Equal(occurrence)
+	}
+	overlap, err := serv.checkPlanDateOverlap(ctx, series.UserID, detached, skip)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot check plan date overlap")
+	}
+	if overlap {
+		return newError(http.StatusBadRequest, "plan date overlap").withCode(codePlanOverlap)
+	}
+
+	var plan model.Plan
+	err = serv.store.ExecTx(ctx.Request().Context(), func(store db.Store) error {
+		arg := db.UpdatePlanArg{
+			ID:      series.ID,
+			ExDates: append(series.ExDates, occurrence),
+			Version: version,
+		}
+		_, err := store.UpdatePlanByID(ctx.Request().Context(), arg)
+		if err != nil {
+			return err
+		}
+		plan, err = store.CreatePlan(ctx.Request().Context(), db.CreatePlanArg{
+			Title:        detached.Title,
+			Description:  detached.Description,
+			StartDate:    detached.StartDate,
+			EndDate:      detached.EndDate,
+			Status:       db.Status(detached.Status),
+			AutoComplete: detached.AutoComplete,
+			Tags:         tagNames(detached.Tags),
+			ParentID:     series.ID,
+			RecurrenceID: occurrence,
+			UserID:       series.UserID,
+		})
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			return newError(http.StatusPreconditionFailed, "plan has been modified").withCode(codeVersionConflict)
+		}
+		return newError(http.StatusInternalServerError, "cannot update plan occurrence")
+	}
+
+	setPlanETag(ctx, &plan)
+	return ctx.JSON(http.StatusOK, planResponse(&plan))
+}
+
+// updateFollowingPlanOccurrences ends the series before occurrence and continues it as a new
+// series carrying the requested changes, a non-zero version guards the write to the old series
+func (serv *Server) updateFollowingPlanOccurrences(ctx echo.Context, series model.Plan, version uint, occurrence time.Time, req *updatePlanRequest) error {
+	rule, err := calendar.ParseRule(series.RecurrenceRule)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot parse recurrence rule")
+	}
+	if rule.Count > 0 {
+		rule.Count -= rule.Index(series.StartDate, occurrence)
+	}
+
+	next := series
+	next.ID = 0
+	next.StartDate = occurrence
+	next.EndDate = occurrence.Add(series.EndDate.Sub(series.StartDate))
+	next.RecurrenceRule = rule.String()
+	next.ExDates = nil
+	for _, exDate := range series.ExDates {
+		if !exDate.Before(occurrence) {
+			next.ExDates = append(next.ExDates, exDate)
+		}
+	}
+	next, err = req.applyTo(next)
+	if err != nil {
+		return patchError(err)
+	}
+
+	skip := func(existing model.Plan) bool {
+		return existing.ID == series.ID && !existing.StartDate.Before(occurrence)
+	}
+	overlap, err := serv.checkPlanDateOverlap(ctx, series.UserID, next, skip)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot check plan date overlap")
+	}
+	if overlap {
+		return newError(http.StatusBadRequest, "plan date overlap").withCode(codePlanOverlap)
+	}
+
+	var plan model.Plan
+	err = serv.store.ExecTx(ctx.Request().Context(), func(store db.Store) error {
+		arg, err := truncatePlanSeries(series, occurrence)
+		if err != nil {
+			return err
+		}
+		arg.Version = version
+		_, err = store.UpdatePlanByID(ctx.Request().Context(), arg)
+		if err != nil {
+			return err
+		}
+		plan, err = store.CreatePlan(ctx.Request().Context(), db.CreatePlanArg{
+			Title:          next.Title,
+			Description:    next.Description,
+			StartDate:      next.StartDate,
+			EndDate:        next.EndDate,
+			Status:         db.Status(next.Status),
+			RecurrenceRule: next.RecurrenceRule,
+			ExDates:        next.ExDates,
+			AutoComplete:   next.AutoComplete,
+			Tags:           tagNames(next.Tags),
+			UserID:         series.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		return store.ReparentPlanExceptions(ctx.Request().Context(), series.ID, plan.ID, occurrence)
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			return newError(http.StatusPreconditionFailed, "plan has been modified").withCode(codeVersionConflict)
+		}
+		return newError(http.StatusInternalServerError, "cannot update following plan occurrences")
+	}
+
+	setPlanETag(ctx, &plan)
+	return ctx.JSON(http.StatusOK, planResponse(&plan))
+}
+
+// truncatePlanSeries returns the update that ends the series right before occurrence
+func truncatePlanSeries(series model.Plan, occurrence time.Time) (db.UpdatePlanArg, error) {
+	rule, err := calendar.ParseRule(series.RecurrenceRule)
+	if err != nil {
+		return db.UpdatePlanArg{}, err
+	}
+	rule.Count = 0
+	rule.Until = occurrence.Add(-time.Second)
+	recurrenceRule := rule.String()
+	return db.UpdatePlanArg{
+		ID:             series.ID,
+		RecurrenceRule: &recurrenceRule,
+	}, nil
+}
+
+// checkPlanDateOverlap reports whether any occurrence of candidate overlaps an occurrence of
+// the user's other plans. Occurrences for which skip returns true are ignored.
+func (serv *Server) checkPlanDateOverlap(ctx echo.Context, userID uint, candidate model.Plan, skip func(model.Plan) bool) (bool, error) {
 	plans, err := serv.store.ListPlansByUserID(ctx.Request().Context(), userID)
 	if err != nil {
-		if err.Error() == db.ErrRecordNotFound {
+		if errors.Is(err, db.ErrRecordNotFound) {
 			return false, nil
 		}
 		return false, err
 	}
+	return planOverlaps(candidate, plans, skip)
+}
+
+// planOverlaps reports whether any occurrence of candidate overlaps an occurrence of plans
+func planOverlaps(candidate model.Plan, plans []model.Plan, skip func(model.Plan) bool) (bool, error) {
+	from, to, err := calendar.Span(candidate)
+	if err != nil {
+		return false, err
+	}
+	occurrences, err := calendar.Expand(candidate, from, to)
+	if err != nil {
+		return false, err
+	}
+	existing, err := calendar.ExpandAll(plans, from, to)
+	if err != nil {
+		return false, err
+	}
 
-	for _, plan := range plans {
-		if plan.StartDate.Before(endDate) && plan.EndDate.After(startDate) {
-			return true, nil
+	for _, plan := range existing {
+		if candidate.ID != 0 && plan.ID == candidate.ID {
+			continue
+		}
+		if skip != nil && skip(plan) {
+			continue
+		}
+		for _, occurrence := range occurrences {
+			if plan.StartDate.Before(occurrence.EndDate) && plan.EndDate.After(occurrence.StartDate) {
+				return true, nil
+			}
 		}
 	}
 
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+func (serv *Server) createPlanItem(ctx echo.Context) error {
+	var req createPlanItemRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request body")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	plan, err := serv.store.GetPlanByID(ctx.Request().Context(), req.PlanID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve plan")
+	}
+	if plan.UserID != payload.UserID {
+		return newError(http.StatusForbidden, "plan does not belong to user")
+	}
+
+	arg := db.CreatePlanItemArg{
+		PlanID:   plan.ID,
+		Title:    req.Title,
+		Position: plan.ItemCount,
+	}
+	item, err := serv.store.CreatePlanItem(ctx.Request().Context(), arg)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot create plan item")
+	}
+
+	return ctx.JSON(http.StatusCreated, planItemResponse(&item))
+}
+
+type (
+	createPlanItemRequest struct {
+		PlanID uint   `param:"id" validate:"required"`
+		Title  string `json:"title" validate:"required"`
+	}
+
+	planItemModel struct {
+		ID        uint      `json:"id"`
+		PlanID    uint      `json:"plan_id"`
+		Title     string    `json:"title"`
+		Position  int       `json:"position"`
+		IsDone    bool      `json:"is_done"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+)
+
+func planItemResponse(item *model.PlanItem) *planItemModel {
+	return &planItemModel{
+		ID:        item.ID,
+		PlanID:    item.PlanID,
+		Title:     item.Title,
+		Position:  item.Position,
+		IsDone:    item.IsDone,
+		CreatedAt: item.CreatedAt,
+		UpdatedAt: item.UpdatedAt,
+	}
+}
+
+func (serv *Server) retrievePlanItems(ctx echo.Context) error {
+	var req retrievePlanItemsRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	plan, err := serv.store.GetPlanByID(ctx.Request().Context(), req.PlanID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve plan")
+	}
+	if plan.UserID != payload.UserID {
+		return newError(http.StatusForbidden, "plan does not belong to user")
+	}
+
+	items, err := serv.store.ListPlanItemsByPlanID(ctx.Request().Context(), plan.ID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve plan items")
+	}
+
+	return ctx.JSON(http.StatusOK, newRetrievePlanItemsResponse(&plan, items))
+}
+
+type (
+	retrievePlanItemsRequest struct {
+		PlanID uint `param:"id" validate:"required"`
+	}
+
+	retrievePlanItemsResponse struct {
+		Items         []planItemModel `json:"items"`
+		ItemCount     int             `json:"item_count"`
+		DoneItemCount int             `json:"done_item_count"`
+		Progress      int             `json:"progress"`
+	}
+)
+
+func newRetrievePlanItemsResponse(plan *model.Plan, items []model.PlanItem) *retrievePlanItemsResponse {
+	res := retrievePlanItemsResponse{
+		Items:         make([]planItemModel, 0, len(items)),
+		ItemCount:     plan.ItemCount,
+		DoneItemCount: plan.DoneItemCount,
+		Progress:      plan.Progress(),
+	}
+	for _, item := range items {
+		res.Items = append(res.Items, *planItemResponse(&item))
+	}
+	return &res
+}
+
+func (serv *Server) updatePlanItem(ctx echo.Context) error {
+	var req updatePlanItemRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request body")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	plan, err := serv.store.GetPlanByID(ctx.Request().Context(), req.PlanID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve plan")
+	}
+	if plan.UserID != payload.UserID {
+		return newError(http.StatusForbidden, "plan does not belong to user")
+	}
+	items, err := serv.store.ListPlanItemsByPlanID(ctx.Request().Context(), plan.ID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve plan items")
+	}
+	index := planItemIndex(items, req.ItemID)
+	if index < 0 {
+		return newError(http.StatusNotFound, "plan item not found")
+	}
+
+	// the move and the edit succeed or fail together
+	var item model.PlanItem
+	err = serv.store.ExecTx(ctx.Request().Context(), func(store db.Store) error {
+		if req.Position != nil {
+			ids := moveItem(items, index, *req.Position)
+			if err := store.ReorderPlanItems(ctx.Request().Context(), plan.ID, ids); err != nil {
+				return newError(http.StatusInternalServerError, "cannot reorder plan items")
+			}
+		}
+		arg := db.UpdatePlanItemArg{
+			ID:     req.ItemID,
+			Title:  req.Title,
+			IsDone: req.IsDone,
+		}
+		var err error
+		item, err = store.UpdatePlanItemByID(ctx.Request().Context(), arg)
+		if err != nil {
+			if errors.Is(err, db.ErrRecordNotFound) {
+				return newError(http.StatusNotFound, "plan item not found")
+			}
+			return newError(http.StatusInternalServerError, "cannot update plan item")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = serv.autoCompletePlan(ctx, plan.ID); err != nil {
+		return newError(http.StatusInternalServerError, "cannot complete plan")
+	}
+	return ctx.JSON(http.StatusOK, planItemResponse(&item))
+}
+
+type (
+	updatePlanItemRequest struct {
+		PlanID   uint   `param:"id" validate:"required"`
+		ItemID   uint   `param:"item_id" validate:"required"`
+		Title    string `json:"title"`
+		IsDone   *bool  `json:"is_done"`
+		Position *int   `json:"position"`
+	}
+)
+
+func (serv *Server) reorderPlanItems(ctx echo.Context) error {
+	var req reorderPlanItemsRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request body")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	plan, err := serv.store.GetPlanByID(ctx.Request().Context(), req.PlanID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve plan")
+	}
+	if plan.UserID != payload.UserID {
+		return newError(http.StatusForbidden, "plan does not belong to user")
+	}
+	items, err := serv.store.ListPlanItemsByPlanID(ctx.Request().Context(), plan.ID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve plan items")
+	}
+
+	if len(req.ItemIDs) != len(items) {
+		return newError(http.StatusBadRequest, "item_ids must list every item of the plan")
+	}
+	seen := map[uint]bool{}
+	for _, id := range req.ItemIDs {
+		if seen[id] || planItemIndex(items, id) < 0 {
+			return newError(http.StatusBadRequest, "item_ids must list every item of the plan")
+		}
+		seen[id] = true
+	}
+
+	err = serv.store.ReorderPlanItems(ctx.Request().Context(), plan.ID, req.ItemIDs)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot reorder plan items")
+	}
+	items, err = serv.store.ListPlanItemsByPlanID(ctx.Request().Context(), plan.ID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve plan items")
+	}
+
+	return ctx.JSON(http.StatusOK, newRetrievePlanItemsResponse(&plan, items))
+}
+
+type (
+	reorderPlanItemsRequest struct {
+		PlanID  uint   `param:"id" validate:"required"`
+		ItemIDs []uint `json:"item_ids" validate:"required"`
+	}
+)
+
+func (serv *Server) deletePlanItem(ctx echo.Context) error {
+	var req deletePlanItemRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	plan, err := serv.store.GetPlanByID(ctx.Request().Context(), req.PlanID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve plan")
+	}
+	if plan.UserID != payload.UserID {
+		return newError(http.StatusForbidden, "plan does not belong to user")
+	}
+	item, err := serv.store.GetPlanItemByID(ctx.Request().Context(), req.ItemID)
+	if err != nil || item.PlanID != plan.ID {
+		if err == nil || errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "plan item not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve plan item")
+	}
+
+	err = serv.store.DeletePlanItemByID(ctx.Request().Context(), item.ID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot delete plan item")
+	}
+
+	if err = serv.autoCompletePlan(ctx, plan.ID); err != nil {
+		return newError(http.StatusInternalServerError, "cannot complete plan")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type (
+	deletePlanItemRequest struct {
+		PlanID uint `param:"id" validate:"required"`
+		ItemID uint `param:"item_id" validate:"required"`
+	}
+)
+
+// autoCompletePlan marks an auto completing plan as done once every item is checked
+func (serv *Server) autoCompletePlan(ctx echo.Context, planID uint) error {
+	plan, err := serv.store.GetPlanByID(ctx.Request().Context(), planID)
+	if err != nil {
+		return err
+	}
+	if !plan.AutoComplete || plan.ItemCount == 0 || plan.DoneItemCount < plan.ItemCount || plan.Status == model.Done {
+		return nil
+	}
+	status := db.Done
+	arg := db.UpdatePlanArg{
+		ID:     plan.ID,
+		Status: &status,
+	}
+	_, err = serv.store.UpdatePlanByID(ctx.Request().Context(), arg)
+	return err
+}
+
+func planItemIndex(items []model.PlanItem, id uint) int {
+	for i, item := range items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// moveItem returns the item ids ordered with the item at index moved to position
+func moveItem(items []model.PlanItem, index, position int) []uint {
+	ids := make([]uint, 0, len(items))
+	for i, item := range items {
+		if i != index {
+			ids = append(ids, item.ID)
+		}
+	}
+	if position < 0 {
+		position = 0
+	}
+	if position > len(ids) {
+		position = len(ids)
+	}
+	ids = append(ids[:position], append([]uint{items[index].ID}, ids[position:]...)...)
+	return ids
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	db "com.github/asdsec/planny/internal/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+const mimeProblemJSON = "application/problem+json"
+
+// Stable machine readable codes sent in the code member of every problem
+const (
+	codeBadRequest           = "bad_request"
+	codeValidationFailed     = "validation_failed"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeConflict             = "conflict"
+	codeDuplicated           = "duplicated"
+	codePlanOverlap          = "plan_overlap"
+	codePatchTestFailed      = "patch_test_failed"
+	codePreconditionFailed   = "precondition_failed"
+	codeVersionConflict      = "version_conflict"
+	codeInvalidToken         = "invalid_token"
+	codeEmailNotVerified     = "email_not_verified"
+	codeInvalidMfaCode       = "invalid_mfa_code"
+	codeInvalidCredentials   = "invalid_credentials"
+	codeInsufficientScope    = "insufficient_scope"
+	codePayloadTooLarge      = "payload_too_large"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeTooManyRequests      = "too_many_requests"
+	codeInternal             = "internal_error"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            codeBadRequest,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusMethodNotAllowed:      codeMethodNotAllowed,
+	http.StatusConflict:              codeConflict,
+	http.StatusPreconditionFailed:    codePreconditionFailed,
+	http.StatusRequestEntityTooLarge: codePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  codeUnsupportedMediaType,
+	http.StatusTooManyRequests:       codeTooManyRequests,
+	http.StatusInternalServerError:   codeInternal,
+}
+
+// apiError is the error handlers return, handleError renders it as an RFC 7807 problem
+type apiError struct {
+	Status int
+	Code   string
+	Detail string
+	// Extensions are additional members of the problem object
+	Extensions map[string]interface{}
+}
+
+// newError returns an error with the code of its status
+func newError(status int, detail string) *apiError {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = codeBadRequest
+		if status >= http.StatusInternalServerError {
+			code = codeInternal
+		}
+	}
+	return &apiError{Status: status, Code: code, Detail: detail}
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Detail)
+}
+
+// withCode replaces the code derived from the status by a more specific one
+func (e *apiError) withCode(code string) *apiError {
+	e.Code = code
+	return e
+}
+
+// with adds an extension member to the problem
+func (e *apiError) with(key string, value interface{}) *apiError {
+	if e.Extensions == nil {
+		e.Extensions = map[string]interface{}{}
+	}
+	e.Extensions[key] = value
+	return e
+}
+
+// toAPIError maps any error escaping a handler, store errors keep their meaning while
+// unknown errors are hidden behind a generic internal error
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		detail := http.StatusText(httpErr.Code)
+		if msg, ok := httpErr.Message.(string); ok {
+			detail = msg
+		}
+		return newError(httpErr.Code, detail)
+	}
+	switch {
+	case errors.Is(err, db.ErrRecordNotFound):
+		return newError(http.StatusNotFound, "resource not found")
+	case errors.Is(err, db.ErrDuplicatedKey):
+		return newError(http.StatusConflict, "resource already exists").withCode(codeDuplicated)
+	case errors.Is(err, db.ErrVersionConflict):
+		return newError(http.StatusPreconditionFailed, "resource has been modified").withCode(codeVersionConflict)
+	case errors.Is(err, db.ErrForeignKeyViolated):
+		return newError(http.StatusConflict, "referenced resource does not exist")
+	}
+	return newError(http.StatusInternalServerError, "internal server error")
+}
+
+// handleError is the echo.HTTPErrorHandler of the server, it renders every error as an
+// application/problem+json response
+func (serv *Server) handleError(err error, ctx echo.Context) {
+	if ctx.Response().Committed {
+		return
+	}
+	apiErr := toAPIError(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Error().Err(err).Str("method", ctx.Request().Method).Str("uri", ctx.Request().RequestURI).Msg("request failed")
+	}
+
+	problem := map[string]interface{}{}
+	for key, value := range apiErr.Extensions {
+		problem[key] = value
+	}
+	problem["type"] = "about:blank"
+	problem["title"] = http.StatusText(apiErr.Status)
+	problem["status"] = apiErr.Status
+	problem["detail"] = apiErr.Detail
+	problem["code"] = apiErr.Code
+	problem["instance"] = ctx.Request().URL.Path
+
+	if ctx.Request().Method == http.MethodHead {
+		err = ctx.NoContent(apiErr.Status)
+	} else {
+		var b []byte
+		if b, err = json.Marshal(problem); err == nil {
+			err = ctx.Blob(apiErr.Status, mimeProblemJSON, b)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("cannot write error response")
+	}
+}
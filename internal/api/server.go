@@ -1,13 +1,26 @@
 package api
 
 import (
+	"bytes"
 	"com.github/asdsec/planny/configs"
+	"com.github/asdsec/planny/internal/mail"
+	"com.github/asdsec/planny/internal/oidc"
 	"com.github/asdsec/planny/internal/security"
 	"com.github/asdsec/planny/internal/store"
+	"encoding/hex"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"net"
 	"net/http"
+	"strings"
+)
+
+// Values of TOKEN_FORMAT and TOKEN_LEGACY_FORMATS
+const (
+	tokenFormatJWT          = "jwt"
+	tokenFormatPasetoLocal  = "paseto.v4.local"
+	tokenFormatPasetoPublic = "paseto.v4.public"
 )
 
 // Server represents the server
@@ -16,45 +29,223 @@ type Server struct {
 	router *echo.Echo
 	token  security.TokenGenerator
 	store  db.Store
+	mailer mail.Sender
+	signer *security.Signer
+	// keys is nil unless tokens are signed with a key set
+	keys *security.KeySet
+	// routeScopes maps the method and path of the routes personal access tokens can call to
+	// the scope they require
+	routeScopes map[string]string
+	// providers are the OpenID Providers students can sign in with by name
+	providers map[string]*oidc.Provider
+	// ipExtractor tells the address of a client, forwarding headers are only believed when they
+	// come from a trusted proxy
+	ipExtractor echo.IPExtractor
 }
 
 // NewServer creates a new server
 func NewServer(conf configs.Config, store db.Store) (*Server, error) {
-	tokenGen, err := security.NewJWTGenerator(conf.TokenSymmetricKey)
+	var keys *security.KeySet
+	var err error
+	if conf.TokenKeySetFile != "" {
+		keys, err = security.LoadKeySet(conf.TokenKeySetFile, conf.TokenKeyGracePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load token key set: %w", err)
+		}
+	}
+	tokenGen, err := newTokenGenerator(conf, conf.TokenFormat, keys)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create token generator: %w", err)
 	}
+	var legacy []security.TokenGenerator
+	for _, format := range strings.Split(conf.TokenLegacyFormats, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" {
+			continue
+		}
+		generator, err := newTokenGenerator(conf, format, keys)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create %s token verifier: %w", format, err)
+		}
+		legacy = append(legacy, generator)
+	}
+	tokenGen = security.NewMultiFormatGenerator(tokenGen, legacy...)
+
+	signer, err := security.NewSigner(conf.TokenSymmetricKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create signer: %w", err)
+	}
+	switch conf.EmailVerification {
+	case verificationOptional, verificationLogin, verificationPlans:
+	default:
+		return nil, fmt.Errorf("invalid email verification mode %q", conf.EmailVerification)
+	}
+
+	providers := map[string]*oidc.Provider{}
+	if conf.OidcProvidersFile != "" {
+		providers, err = oidc.LoadProviders(conf.OidcProvidersFile, func(name string) string {
+			return oidcRedirectURL(conf.APIURL, name)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot load oidc providers: %w", err)
+		}
+	}
+
+	ipExtractor, err := newIPExtractor(conf.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	mailer := mail.NewLogSender()
+	if conf.MailSender == "smtp" {
+		mailer, err = mail.NewSMTPSender(conf.SMTPAddress, conf.SMTPUsername, conf.SMTPPassword, conf.MailFrom)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create mail sender: %w", err)
+		}
+	}
 
 	serv := &Server{
-		conf:  conf,
-		token: tokenGen,
-		store: store,
+		conf:        conf,
+		token:       tokenGen,
+		store:       store,
+		mailer:      mailer,
+		signer:      signer,
+		keys:        keys,
+		providers:   providers,
+		ipExtractor: ipExtractor,
 	}
 	serv.setupRouter()
 	return serv, nil
 }
 
+// newTokenGenerator creates the generator of a TOKEN_FORMAT, JSON Web Tokens are signed with
+// the key set when there is one
+func newTokenGenerator(conf configs.Config, format string, keys *security.KeySet) (security.TokenGenerator, error) {
+	switch format {
+	case tokenFormatJWT:
+		if keys != nil {
+			return security.NewJWTKeySetGenerator(keys)
+		}
+		return security.NewJWTGenerator(conf.TokenSymmetricKey)
+	case tokenFormatPasetoLocal:
+		key, err := hex.DecodeString(conf.PasetoLocalKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PASETO_LOCAL_KEY: %w", err)
+		}
+		return security.NewPasetoLocalGenerator(key)
+	case tokenFormatPasetoPublic:
+		privateKey, err := security.LoadEd25519PrivateKey(conf.PasetoPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return security.NewPasetoPublicGenerator(privateKey)
+	default:
+		return nil, fmt.Errorf("unknown token format %q", format)
+	}
+}
+
+// newIPExtractor reads client addresses from X-Forwarded-For when requests pass through the
+// comma separated CIDRs of TRUSTED_PROXIES, and from the connection otherwise. Without it echo
+// would believe the forwarding headers of anyone.
+func newIPExtractor(trustedProxies string) (echo.IPExtractor, error) {
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, cidr := range strings.Split(trustedProxies, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	if len(options) == 3 {
+		return echo.ExtractIPDirect(), nil
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// calendarFeedPath is the route of the calendar feed, its token is the only credential of the feed
+const calendarFeedPath = "/api/v1/calendar/feed/:token"
+
+// logURI writes the request URI to the access log, the calendar feed is logged by its route so
+// that feed tokens do not end up in log files
+func logURI(c echo.Context, buf *bytes.Buffer) (int, error) {
+	if c.Path() == calendarFeedPath {
+		return buf.WriteString(calendarFeedPath)
+	}
+	return buf.WriteString(c.Request().RequestURI)
+}
+
 func (serv *Server) setupRouter() {
+	serv.routeScopes = map[string]string{}
 	e := echo.New()
+	e.IPExtractor = serv.ipExtractor
+	e.Validator = defaultValidator
+	e.HTTPErrorHandler = serv.handleError
 	e.Use(middleware.CORS())
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-		Format: "method=${method}, uri=${uri}, status=${status}\n",
+		Format:        "method=${method}, uri=${custom}, status=${status}\n",
+		CustomTagFunc: logURI,
 	}))
 
+	e.GET("/.well-known/jwks.json", serv.retrieveJWKS)
+
 	v1 := e.Group("/api/v1")
 	v1.GET("/health", func(c echo.Context) error {
 		return c.NoContent(http.StatusOK)
 	})
 	v1.POST("/login", serv.login)
+	v1.POST("/login/mfa", serv.loginMfa)
+	v1.POST("/login/magic", serv.requestMagicLink)
+	v1.POST("/login/magic/verify", serv.loginMagicLink)
 	v1.POST("/register", serv.register)
 	v1.POST("/renew_access", serv.renewAccess)
+	v1.POST("/password/forgot", serv.forgotPassword)
+	v1.POST("/password/reset", serv.resetPassword)
+	v1.POST("/email/verify", serv.verifyEmail)
+	v1.POST("/email/verify/resend", serv.resendEmailVerification)
+	v1.GET("/calendar/feed/:token", serv.calendarFeed)
+	v1.GET("/oidc/providers", serv.retrieveOidcProviders)
+	v1.GET("/oidc/:provider/login", serv.oidcLogin)
+	v1.GET("/oidc/:provider/callback", serv.oidcCallback)
 
 	authorized := v1.Group("")
 	authorized.Use(serv.authMiddleware)
-	authorized.POST("/plans", serv.createPlan)
-	authorized.GET("/plans", serv.retrievePlans)
-	authorized.PATCH("/plans/:id", serv.updatePlan)
-	authorized.DELETE("/plans/:id", serv.deletePlan)
+	authorized.POST("/logout", serv.logout)
+	authorized.POST("/logout_all", serv.logoutAll)
+	authorized.GET("/me", serv.retrieveMe)
+	authorized.PATCH("/me", serv.updateMe)
+	authorized.DELETE("/me", serv.deleteMe)
+	authorized.POST("/me/password", serv.changePassword)
+	authorized.POST("/me/totp", serv.enrollTotp)
+	authorized.POST("/me/totp/confirm", serv.confirmTotp)
+	authorized.DELETE("/me/totp", serv.disableTotp)
+	authorized.GET("/sessions", serv.retrieveSessions)
+	authorized.DELETE("/sessions/:id", serv.deleteSession)
+	authorized.GET("/tokens", serv.retrievePersonalAccessTokens)
+	authorized.POST("/tokens", serv.createPersonalAccessToken)
+	authorized.DELETE("/tokens/:id", serv.deletePersonalAccessToken)
+	serv.scoped(scopePlansWrite, authorized.POST("/plans", serv.createPlan, serv.requireVerifiedEmail))
+	serv.scoped(scopePlansRead, authorized.GET("/plans", serv.retrievePlans))
+	serv.scoped(scopePlansRead, authorized.GET("/plans/free_slots", serv.retrieveFreeSlots))
+	serv.scoped(scopePlansRead, authorized.GET("/plans/:id", serv.retrievePlan))
+	serv.scoped(scopePlansWrite, authorized.PATCH("/plans/:id", serv.updatePlan))
+	serv.scoped(scopePlansWrite, authorized.DELETE("/plans/:id", serv.deletePlan))
+	serv.scoped(scopePlansRead, authorized.GET("/plans/:id/items", serv.retrievePlanItems))
+	serv.scoped(scopePlansWrite, authorized.POST("/plans/:id/items", serv.createPlanItem))
+	serv.scoped(scopePlansWrite, authorized.PUT("/plans/:id/items/order", serv.reorderPlanItems))
+	serv.scoped(scopePlansWrite, authorized.PATCH("/plans/:id/items/:item_id", serv.updatePlanItem))
+	serv.scoped(scopePlansWrite, authorized.DELETE("/plans/:id/items/:item_id", serv.deletePlanItem))
+	serv.scoped(scopeTagsRead, authorized.GET("/tags", serv.retrieveTags))
+	serv.scoped(scopeTagsWrite, authorized.POST("/tags", serv.createTag))
+	serv.scoped(scopeTagsWrite, authorized.PATCH("/tags/:id", serv.updateTag))
+	serv.scoped(scopeTagsWrite, authorized.DELETE("/tags/:id", serv.deleteTag))
+	serv.scoped(scopePlansRead, authorized.GET("/plans.ics", serv.exportPlans))
+	serv.scoped(scopePlansWrite, authorized.POST("/plans/import", serv.importPlans, serv.requireVerifiedEmail))
+	authorized.POST("/calendar/feed", serv.createCalendarFeed)
+	authorized.DELETE("/calendar/feed", serv.deleteCalendarFeed)
 
 	serv.router = e
 }
@@ -66,7 +257,3 @@ func (serv *Server) Start() error {
 	}
 	return serv.router.Start(serv.conf.ServerAddress)
 }
-
-func (serv *Server) err(ctx echo.Context, code int, msg string) error {
-	return ctx.JSON(code, echo.Map{"error": msg})
-}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"com.github/asdsec/planny/internal/useragent"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+func (serv *Server) retrieveSessions(ctx echo.Context) error {
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	sessions, err := serv.store.ListActiveSessionsByUserID(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve sessions")
+	}
+
+	rsp := retrieveSessionsResponse{Sessions: make([]sessionModel, 0, len(sessions))}
+	for _, session := range sessions {
+		rsp.Sessions = append(rsp.Sessions, *sessionResponse(&session, payload.SessionID))
+	}
+	return ctx.JSON(http.StatusOK, rsp)
+}
+
+type (
+	sessionModel struct {
+		ID         uuid.UUID `json:"id"`
+		Device     string    `json:"device"`
+		DeviceType string    `json:"device_type"`
+		Browser    string    `json:"browser,omitempty"`
+		OS         string    `json:"os,omitempty"`
+		UserAgent  string    `json:"user_agent"`
+		ClientIP   string    `json:"client_ip"`
+		Current    bool      `json:"current"`
+		CreatedAt  time.Time `json:"created_at"`
+		LastUsedAt time.Time `json:"last_used_at"`
+		ExpiresAt  time.Time `json:"expires_at"`
+	}
+
+	retrieveSessionsResponse struct {
+		Sessions []sessionModel `json:"sessions"`
+	}
+)
+
+func sessionResponse(session *model.Session, currentID uuid.UUID) *sessionModel {
+	agent := useragent.Parse(session.UserAgent)
+	return &sessionModel{
+		ID:         session.ID,
+		Device:     agent.String(),
+		DeviceType: agent.Device,
+		Browser:    agent.Browser,
+		OS:         agent.OS,
+		UserAgent:  session.UserAgent,
+		ClientIP:   session.ClientIp,
+		Current:    session.ID == currentID,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastUsedAt,
+		ExpiresAt:  session.ExpiresAt,
+	}
+}
+
+func (serv *Server) deleteSession(ctx echo.Context) error {
+	var req deleteSessionRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return newError(http.StatusBadRequest, "invalid session id")
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	session, err := serv.store.GetSessionByID(ctx.Request().Context(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "session not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve session")
+	}
+	// sessions of other users are reported as missing so that ids cannot be probed
+	if session.UserID != payload.UserID || session.IsBlocked {
+		return newError(http.StatusNotFound, "session not found")
+	}
+
+	if err = serv.store.BlockSession(ctx.Request().Context(), session.ID); err != nil {
+		return newError(http.StatusInternalServerError, "cannot revoke session")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type (
+	deleteSessionRequest struct {
+		ID string `param:"id" validate:"required"`
+	}
+)
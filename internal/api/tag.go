@@ -0,0 +1,218 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const maxTagNameLength = 32
+
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func (serv *Server) createTag(ctx echo.Context) error {
+	var req createTagRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request body")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+	name, err := normalizeTagName(req.Name)
+	if err != nil {
+		return newError(http.StatusBadRequest, err.Error())
+	}
+	if req.Color != "" && !tagColorPattern.MatchString(req.Color) {
+		return newError(http.StatusBadRequest, "color must be a #rrggbb value")
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	arg := db.CreateTagArg{
+		Name:   name,
+		Color:  req.Color,
+		UserID: payload.UserID,
+	}
+	tag, err := serv.store.CreateTag(ctx.Request().Context(), arg)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicatedKey) {
+			return newError(http.StatusConflict, "tag already exists").withCode(codeDuplicated)
+		}
+		return newError(http.StatusInternalServerError, "cannot create tag")
+	}
+
+	return ctx.JSON(http.StatusCreated, tagResponse(&tag))
+}
+
+type (
+	createTagRequest struct {
+		Name  string `json:"name" validate:"required"`
+		Color string `json:"color"`
+	}
+
+	tagModel struct {
+		ID        uint      `json:"id"`
+		Name      string    `json:"name"`
+		Color     string    `json:"color,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+)
+
+func tagResponse(tag *model.Tag) *tagModel {
+	return &tagModel{
+		ID:        tag.ID,
+		Name:      tag.Name,
+		Color:     tag.Color,
+		CreatedAt: tag.CreatedAt,
+		UpdatedAt: tag.UpdatedAt,
+	}
+}
+
+func (serv *Server) retrieveTags(ctx echo.Context) error {
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	tags, err := serv.store.ListTagsByUserID(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot retrieve tags")
+	}
+
+	rsp := retrieveTagsResponse{Tags: make([]tagModel, 0, len(tags))}
+	for _, tag := range tags {
+		rsp.Tags = append(rsp.Tags, *tagResponse(&tag))
+	}
+	return ctx.JSON(http.StatusOK, rsp)
+}
+
+type (
+	retrieveTagsResponse struct {
+		Tags []tagModel `json:"tags"`
+	}
+)
+
+func (serv *Server) updateTag(ctx echo.Context) error {
+	var req updateTagRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request body")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+	if req.Name != nil {
+		name, err := normalizeTagName(*req.Name)
+		if err != nil {
+			return newError(http.StatusBadRequest, err.Error())
+		}
+		req.Name = &name
+	}
+	// an empty color clears it
+	if req.Color != nil && *req.Color != "" && !tagColorPattern.MatchString(*req.Color) {
+		return newError(http.StatusBadRequest, "color must be a #rrggbb value")
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	tag, err := serv.store.GetTagByID(ctx.Request().Context(), req.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "tag not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve tag")
+	}
+	if tag.UserID != payload.UserID {
+		return newError(http.StatusForbidden, "tag does not belong to user")
+	}
+
+	arg := db.UpdateTagArg{
+		ID:    req.ID,
+		Name:  req.Name,
+		Color: req.Color,
+	}
+	tag, err = serv.store.UpdateTagByID(ctx.Request().Context(), arg)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicatedKey) {
+			return newError(http.StatusConflict, "tag already exists").withCode(codeDuplicated)
+		}
+		return newError(http.StatusInternalServerError, "cannot update tag")
+	}
+
+	return ctx.JSON(http.StatusOK, tagResponse(&tag))
+}
+
+type (
+	updateTagRequest struct {
+		ID    uint    `param:"id" validate:"required"`
+		Name  *string `json:"name"`
+		Color *string `json:"color"`
+	}
+)
+
+func (serv *Server) deleteTag(ctx echo.Context) error {
+	var req deleteTagRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "cannot bind request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	tag, err := serv.store.GetTagByID(ctx.Request().Context(), req.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "tag not found")
+		}
+		return newError(http.StatusInternalServerError, "cannot retrieve tag")
+	}
+	if tag.UserID != payload.UserID {
+		return newError(http.StatusForbidden, "tag does not belong to user")
+	}
+
+	err = serv.store.DeleteTagByID(ctx.Request().Context(), tag.ID)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "cannot delete tag")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type (
+	deleteTagRequest struct {
+		ID uint `param:"id" validate:"required"`
+	}
+)
+
+// normalizeTagName trims and lower cases a tag name so that "Physics" and "physics" match
+func normalizeTagName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", fmt.Errorf("tag name is required")
+	}
+	if len(name) > maxTagNameLength {
+		return "", fmt.Errorf("tag name must be at most %d characters", maxTagNameLength)
+	}
+	return name, nil
+}
+
+// normalizeTagNames normalizes and de-duplicates tag names, nil stays nil
+func normalizeTagNames(names []string) ([]string, error) {
+	if names == nil {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name, err := normalizeTagName(name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[name] {
+			seen[name] = true
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized, nil
+}
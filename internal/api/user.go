@@ -0,0 +1,180 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"com.github/asdsec/planny/internal/security"
+	db "com.github/asdsec/planny/internal/store"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+func (serv *Server) retrieveMe(ctx echo.Context) error {
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	user, err := serv.store.GetUserById(ctx.Request().Context(), payload.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "user not found")
+		}
+		return newError(http.StatusInternalServerError, "failed to get user")
+	}
+	return ctx.JSON(http.StatusOK, newMeResponse(&user))
+}
+
+type (
+	meResponse struct {
+		ID            uint      `json:"id"`
+		Username      string    `json:"username"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		TotpEnabled   bool      `json:"totp_enabled"`
+		FirstName     string    `json:"first_name"`
+		LastName      string    `json:"last_name"`
+		DisplayName   string    `json:"display_name"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		CreatedAt     time.Time `json:"created_at"`
+	}
+)
+
+func newMeResponse(user *model.User) *meResponse {
+	return &meResponse{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		TotpEnabled:   user.TotpEnabled,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		DisplayName:   user.FirstName + " " + user.LastName,
+		UpdatedAt:     user.UpdatedAt,
+		CreatedAt:     user.CreatedAt,
+	}
+}
+
+// updateMe changes the profile of the student, a new email address is sent a verification link
+func (serv *Server) updateMe(ctx echo.Context) error {
+	var req updateMeRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	arg := db.UpdateUserArg{
+		ID:        payload.UserID,
+		Username:  req.Username,
+		Email:     req.Email,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+	}
+	user, err := serv.store.UpdateUser(ctx.Request().Context(), arg)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "user not found")
+		}
+		if errors.Is(err, db.ErrDuplicatedKey) {
+			return newError(http.StatusConflict, "username or email already exists").withCode(codeDuplicated)
+		}
+		return newError(http.StatusInternalServerError, "failed to update user")
+	}
+	if req.Email != nil && !user.EmailVerified {
+		go serv.sendEmailVerification(user)
+	}
+	return ctx.JSON(http.StatusOK, newMeResponse(&user))
+}
+
+type (
+	updateMeRequest struct {
+		Username  *string `json:"username" validate:"omitempty,min=1"`
+		Email     *string `json:"email" validate:"omitempty,email"`
+		FirstName *string `json:"first_name" validate:"omitempty,min=1,max=32"`
+		LastName  *string `json:"last_name" validate:"omitempty,min=1,max=32"`
+	}
+)
+
+// changePassword replaces the password of the student after checking the current one, every
+// other session is revoked while the one making the request stays signed in
+func (serv *Server) changePassword(ctx echo.Context) error {
+	var req changePasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	if err := serv.checkCurrentPassword(ctx, payload.UserID, req.CurrentPassword); err != nil {
+		return err
+	}
+	password, err := security.HashPassword(req.NewPassword)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to hash password")
+	}
+	err = serv.store.ExecTx(ctx.Request().Context(), func(store db.Store) error {
+		if err := store.UpdateUserPassword(ctx.Request().Context(), payload.UserID, password); err != nil {
+			return err
+		}
+		return store.BlockOtherSessions(ctx.Request().Context(), payload.UserID, payload.SessionID)
+	})
+	if err != nil {
+		return newError(http.StatusInternalServerError, "failed to change password")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type (
+	changePasswordRequest struct {
+		CurrentPassword string `json:"current_password" validate:"required"`
+		NewPassword     string `json:"new_password" validate:"required,min=6,max=32"`
+	}
+)
+
+// deleteMe removes the account of the student with everything it owns, the password is asked
+// again so that a leaked access token alone cannot do it
+func (serv *Server) deleteMe(ctx echo.Context) error {
+	var req deleteMeRequest
+	if err := ctx.Bind(&req); err != nil {
+		return newError(http.StatusBadRequest, "invalid request")
+	}
+	if err := ctx.Validate(&req); err != nil {
+		return validationError(err)
+	}
+
+	payload := ctx.Get(authorizationPayloadKey).(*security.TokenPayload)
+	if err := serv.checkCurrentPassword(ctx, payload.UserID, req.Password); err != nil {
+		return err
+	}
+	if err := serv.store.DeleteUser(ctx.Request().Context(), payload.UserID); err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "user not found")
+		}
+		return newError(http.StatusInternalServerError, "failed to delete user")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type (
+	deleteMeRequest struct {
+		Password string `json:"password" validate:"required"`
+	}
+)
+
+// checkCurrentPassword answers 403 rather than 401 on a wrong password since the access token
+// itself is fine and clients should not try to renew it
+func (serv *Server) checkCurrentPassword(ctx echo.Context, userID uint, password string) error {
+	user, err := serv.store.GetUserById(ctx.Request().Context(), userID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			return newError(http.StatusNotFound, "user not found")
+		}
+		return newError(http.StatusInternalServerError, "failed to get user")
+	}
+	if err = security.CheckPassword(password, user.Password); err != nil {
+		return newError(http.StatusForbidden, "incorrect password")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// requestValidator validates requests against their validate tags, it is registered as the
+// echo.Validator of the server and reports fields by the name the client sent them with
+type requestValidator struct {
+	validate *validator.Validate
+}
+
+var defaultValidator = newRequestValidator()
+
+func newRequestValidator() *requestValidator {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, key := range []string{"json", "param", "query", "form"} {
+			name := strings.SplitN(field.Tag.Get(key), ",", 2)[0]
+			if name != "" && name != "-" {
+				return name
+			}
+		}
+		return field.Name
+	})
+	_ = validate.RegisterValidation("plan_status", func(fl validator.FieldLevel) bool {
+		switch model.Status(fl.Field().String()) {
+		case model.Done, model.InProgress, model.Cancelled:
+			return true
+		}
+		return false
+	})
+	_ = validate.RegisterValidation("token_scope", func(fl validator.FieldLevel) bool {
+		for _, scope := range tokenScopes {
+			if fl.Field().String() == scope {
+				return true
+			}
+		}
+		return false
+	})
+	return &requestValidator{validate: validate}
+}
+
+func (v *requestValidator) Validate(i interface{}) error {
+	return v.validate.Struct(i)
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationError reports the fields that failed validation
+func validationError(err error) error {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return newError(http.StatusBadRequest, err.Error())
+	}
+	fields := make([]fieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, fieldError{Field: fe.Field(), Message: validationMessage(fe)})
+	}
+	return newError(http.StatusBadRequest, "invalid request").withCode(codeValidationFailed).with("fields", fields)
+}
+
+func validationMessage(fe validator.FieldError) string {
+	unit := ""
+	if fe.Kind() == reflect.String {
+		unit = " characters"
+	} else if fe.Kind() == reflect.Slice {
+		unit = " items"
+	}
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		return fmt.Sprintf("must be at least %s%s", fe.Param(), unit)
+	case "max":
+		return fmt.Sprintf("must be at most %s%s", fe.Param(), unit)
+	case "len":
+		return fmt.Sprintf("must be exactly %s%s", fe.Param(), unit)
+	case "numeric":
+		return "must contain digits only"
+	case "gtfield":
+		return fmt.Sprintf("must be after %s", snakeCase(fe.Param()))
+	case "plan_status":
+		return fmt.Sprintf("must be one of %s, %s, %s", model.InProgress, model.Done, model.Cancelled)
+	case "token_scope":
+		return fmt.Sprintf("must be one of %s", strings.Join(tokenScopes, ", "))
+	default:
+		return fmt.Sprintf("failed the %s rule", fe.Tag())
+	}
+}
+
+// snakeCase turns a Go field name such as StartDate into its JSON name start_date
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package calendar
+
+import (
+	"sort"
+	"time"
+
+	"com.github/asdsec/planny/internal/model"
+)
+
+// Horizon limits how far unbounded series are expanded when no window end is known
+const Horizon = 366 * 24 * time.Hour
+
+// Expand returns the occurrences of plan that intersect [from, to). Every
+// occurrence of a recurring plan keeps the series ID and carries its original
+// start in RecurrenceID. Single plans are returned as is when they intersect.
+func Expand(plan model.Plan, from, to time.Time) ([]model.Plan, error) {
+	if !plan.IsRecurring() {
+		if intersects(plan.StartDate, plan.EndDate, from, to) {
+			return []model.Plan{plan}, nil
+		}
+		return nil, nil
+	}
+
+	rule, err := ParseRule(plan.RecurrenceRule)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := plan.EndDate.Sub(plan.StartDate)
+	var occurrences []model.Plan
+	rule.Iterate(plan.StartDate, func(start time.Time) bool {
+		if !start.Before(to) {
+			return false
+		}
+		end := start.Add(duration)
+		if !end.After(from) || isExcluded(plan.ExDates, start) {
+			return true
+		}
+		occurrence := plan
+		occurrence.StartDate = start
+		occurrence.EndDate = end
+		occurrence.RecurrenceID = start
+		occurrences = append(occurrences, occurrence)
+		return true
+	})
+	return occurrences, nil
+}
+
+// ExpandAll expands every plan over [from, to) and sorts the result by start date
+func ExpandAll(plans []model.Plan, from, to time.Time) ([]model.Plan, error) {
+	var occurrences []model.Plan
+	for _, plan := range plans {
+		expanded, err := Expand(plan, from, to)
+		if err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, expanded...)
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].StartDate.Before(occurrences[j].StartDate)
+	})
+	return occurrences, nil
+}
+
+// Span returns the window covered by the plan. Unbounded series are cut at
+// Horizon after their first occurrence.
+func Span(plan model.Plan) (time.Time, time.Time, error) {
+	if !plan.IsRecurring() {
+		return plan.StartDate, plan.EndDate, nil
+	}
+
+	rule, err := ParseRule(plan.RecurrenceRule)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !rule.IsBounded() {
+		return plan.StartDate, plan.StartDate.Add(Horizon), nil
+	}
+
+	last := plan.StartDate
+	rule.Iterate(plan.StartDate, func(start time.Time) bool {
+		last = start
+		return true
+	})
+	return plan.StartDate, last.Add(plan.EndDate.Sub(plan.StartDate)), nil
+}
+
+// IsOccurrence reports whether start is an occurrence of the recurring plan
+func IsOccurrence(plan model.Plan, start time.Time) bool {
+	rule, err := ParseRule(plan.RecurrenceRule)
+	if err != nil {
+		return false
+	}
+	return rule.Index(plan.StartDate, start) >= 0 && !isExcluded(plan.ExDates, start)
+}
+
+func isExcluded(exDates []time.Time, start time.Time) bool {
+	for _, exDate := range exDates {
+		if exDate.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(start, end, from, to time.Time) bool {
+	return start.Before(to) && end.After(from)
+}
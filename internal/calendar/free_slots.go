@@ -0,0 +1,104 @@
+package calendar
+
+import (
+	"sort"
+	"time"
+
+	"com.github/asdsec/planny/internal/model"
+)
+
+// Slot is a free time range
+type Slot struct {
+	Start time.Time
+	End   time.Time
+}
+
+// WorkingHours restricts free slots to a daily time range, Start and End are offsets from
+// midnight in Location
+type WorkingHours struct {
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// FreeSlots returns the free ranges within [from, to) that are at least duration long,
+// given the occurrences of plans and optional working hours
+func FreeSlots(plans []model.Plan, from, to time.Time, duration time.Duration, hours *WorkingHours) ([]Slot, error) {
+	busy, err := ExpandAll(plans, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var free []Slot
+	for _, window := range availableWindows(from, to, hours) {
+		cursor := window.Start
+		for _, plan := range busy {
+			if !plan.EndDate.After(cursor) {
+				continue
+			}
+			if !plan.StartDate.Before(window.End) {
+				break
+			}
+			if plan.StartDate.Sub(cursor) >= duration {
+				free = append(free, Slot{Start: cursor, End: plan.StartDate})
+			}
+			cursor = plan.EndDate
+		}
+		if window.End.Sub(cursor) >= duration {
+			free = append(free, Slot{Start: cursor, End: window.End})
+		}
+	}
+	return free, nil
+}
+
+// NearestSlots returns up to limit placements of duration inside free that start closest to at
+func NearestSlots(free []Slot, at time.Time, duration time.Duration, limit int) []Slot {
+	placements := make([]Slot, 0, len(free))
+	for _, slot := range free {
+		start := at
+		if start.Before(slot.Start) {
+			start = slot.Start
+		}
+		if latest := slot.End.Add(-duration); start.After(latest) {
+			start = latest
+		}
+		placements = append(placements, Slot{Start: start, End: start.Add(duration)})
+	}
+
+	distance := func(slot Slot) time.Duration {
+		if d := slot.Start.Sub(at); d >= 0 {
+			return d
+		}
+		return at.Sub(slot.Start)
+	}
+	sort.SliceStable(placements, func(i, j int) bool {
+		return distance(placements[i]) < distance(placements[j])
+	})
+	if len(placements) > limit {
+		placements = placements[:limit]
+	}
+	return placements
+}
+
+func availableWindows(from, to time.Time, hours *WorkingHours) []Slot {
+	if hours == nil {
+		return []Slot{{Start: from, End: to}}
+	}
+
+	var windows []Slot
+	local := from.In(hours.Location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, hours.Location)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		start, end := day.Add(hours.Start), day.Add(hours.End)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if start.Before(end) {
+			windows = append(windows, Slot{Start: start, End: end})
+		}
+	}
+	return windows
+}
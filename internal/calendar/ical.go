@@ -0,0 +1,126 @@
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"com.github/asdsec/planny/internal/model"
+)
+
+const (
+	productID     = "-//planny//planny//EN"
+	maxLineOctets = 75
+)
+
+// PlanUID returns the iCalendar UID of the series the plan belongs to, imported plans keep
+// the UID they were imported with
+func PlanUID(plan *model.Plan) string {
+	if plan.ExternalUID != "" {
+		return plan.ExternalUID
+	}
+	id := plan.ID
+	if plan.ParentID != 0 {
+		id = plan.ParentID
+	}
+	return fmt.Sprintf("plan-%d@planny", id)
+}
+
+// WriteCalendar renders plans as a VCALENDAR document with one VEVENT per plan
+func WriteCalendar(w io.Writer, name string, plans []model.Plan) error {
+	cw := &contentWriter{w: bufio.NewWriter(w)}
+	cw.line("BEGIN", "VCALENDAR")
+	cw.line("VERSION", "2.0")
+	cw.line("PRODID", productID)
+	cw.line("CALSCALE", "GREGORIAN")
+	cw.line("METHOD", "PUBLISH")
+	if name != "" {
+		cw.line("X-WR-CALNAME", escapeText(name))
+	}
+	stamp := FormatDateTime(time.Now())
+	for i := range plans {
+		writeEvent(cw, &plans[i], stamp)
+	}
+	cw.line("END", "VCALENDAR")
+	if cw.err != nil {
+		return cw.err
+	}
+	return cw.w.Flush()
+}
+
+func writeEvent(cw *contentWriter, plan *model.Plan, stamp string) {
+	cw.line("BEGIN", "VEVENT")
+	cw.line("UID", PlanUID(plan))
+	cw.line("DTSTAMP", stamp)
+	cw.line("DTSTART", FormatDateTime(plan.StartDate))
+	cw.line("DTEND", FormatDateTime(plan.EndDate))
+	if !plan.RecurrenceID.IsZero() {
+		cw.line("RECURRENCE-ID", FormatDateTime(plan.RecurrenceID))
+	}
+	if plan.RecurrenceRule != "" {
+		cw.line("RRULE", plan.RecurrenceRule)
+	}
+	for _, exDate := range plan.ExDates {
+		cw.line("EXDATE", FormatDateTime(exDate))
+	}
+	cw.line("SUMMARY", escapeText(plan.Title))
+	if plan.Description != "" {
+		cw.line("DESCRIPTION", escapeText(plan.Description))
+	}
+	cw.line("STATUS", eventStatus(plan.Status))
+	cw.line("X-PLANNY-STATUS", strings.ToUpper(string(plan.Status)))
+	cw.line("CREATED", FormatDateTime(plan.CreatedAt))
+	cw.line("LAST-MODIFIED", FormatDateTime(plan.UpdatedAt))
+	cw.line("END", "VEVENT")
+}
+
+// eventStatus maps a plan status to a VEVENT STATUS. RFC 5545 reserves COMPLETED for VTODO,
+// so finished plans are published as CONFIRMED and keep their state in X-PLANNY-STATUS.
+func eventStatus(status model.Status) string {
+	switch status {
+	case model.Cancelled:
+		return "CANCELLED"
+	default:
+		return "CONFIRMED"
+	}
+}
+
+func escapeText(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(value)
+}
+
+// contentWriter writes folded content lines and keeps the first error
+type contentWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (cw *contentWriter) line(name, value string) {
+	if cw.err != nil {
+		return
+	}
+	line := name + ":" + value
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		// never split a multi-byte character
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		if _, cw.err = cw.w.WriteString(line[:cut] + "\r\n "); cw.err != nil {
+			return
+		}
+		line = line[cut:]
+		// continuation lines start with a space
+		limit = maxLineOctets - 1
+	}
+	_, cw.err = cw.w.WriteString(line + "\r\n")
+}
@@ -0,0 +1,257 @@
+package calendar
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.github/asdsec/planny/internal/model"
+)
+
+var ErrInvalidCalendar = errors.New("invalid calendar")
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// Event is a VEVENT read from an iCalendar document
+type Event struct {
+	UID            string
+	Summary        string
+	Description    string
+	StartDate      time.Time
+	EndDate        time.Time
+	Status         model.Status
+	RecurrenceRule string
+	ExDates        []time.Time
+	RecurrenceID   time.Time
+}
+
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// ParseCalendar reads the VEVENTs of an iCalendar document, other components are ignored
+func ParseCalendar(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var current []property
+	depth, eventDepth, seenCalendar := 0, 0, false
+	for n, line := range lines {
+		if line == "" {
+			continue
+		}
+		prop, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidCalendar, n+1, err)
+		}
+		switch prop.name {
+		case "BEGIN":
+			depth++
+			switch strings.ToUpper(prop.value) {
+			case "VCALENDAR":
+				seenCalendar = true
+			case "VEVENT":
+				eventDepth, current = depth, nil
+			}
+		case "END":
+			depth--
+			if eventDepth > 0 && depth < eventDepth {
+				eventDepth = 0
+				event, err := newEvent(current)
+				if err != nil {
+					return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidCalendar, n+1, err)
+				}
+				events = append(events, event)
+			}
+		default:
+			// properties of nested components such as VALARM are ignored
+			if eventDepth > 0 && depth == eventDepth {
+				current = append(current, prop)
+			}
+		}
+	}
+	if !seenCalendar || depth != 0 {
+		return nil, fmt.Errorf("%w: unbalanced or missing VCALENDAR", ErrInvalidCalendar)
+	}
+	return events, nil
+}
+
+func newEvent(props []property) (Event, error) {
+	var event Event
+	var duration time.Duration
+	var hasDuration, allDay bool
+	status := ""
+	for _, prop := range props {
+		var err error
+		switch prop.name {
+		case "UID":
+			event.UID = prop.value
+		case "SUMMARY":
+			event.Summary = unescapeText(prop.value)
+		case "DESCRIPTION":
+			event.Description = unescapeText(prop.value)
+		case "DTSTART":
+			event.StartDate, err = parsePropertyTime(prop)
+			allDay = prop.params["VALUE"] == "DATE" || len(prop.value) == len(dateFormat)
+		case "DTEND":
+			event.EndDate, err = parsePropertyTime(prop)
+		case "DURATION":
+			duration, err = parseDuration(prop.value)
+			hasDuration = true
+		case "RRULE":
+			var rule *Rule
+			rule, err = ParseRule(prop.value)
+			if err == nil {
+				event.RecurrenceRule = rule.String()
+			}
+		case "EXDATE":
+			for _, value := range strings.Split(prop.value, ",") {
+				var exDate time.Time
+				exDate, err = parsePropertyTime(property{name: prop.name, params: prop.params, value: value})
+				if err != nil {
+					break
+				}
+				event.ExDates = append(event.ExDates, exDate)
+			}
+		case "RECURRENCE-ID":
+			event.RecurrenceID, err = parsePropertyTime(prop)
+		case "STATUS":
+			if status == "" {
+				status = strings.ToUpper(prop.value)
+			}
+		case "X-PLANNY-STATUS":
+			status = strings.ToUpper(prop.value)
+		}
+		if err != nil {
+			return Event{}, fmt.Errorf("%s: %w", prop.name, err)
+		}
+	}
+
+	if event.StartDate.IsZero() {
+		return Event{}, errors.New("DTSTART is required")
+	}
+	if event.EndDate.IsZero() {
+		switch {
+		case hasDuration:
+			event.EndDate = event.StartDate.Add(duration)
+		case allDay:
+			event.EndDate = event.StartDate.AddDate(0, 0, 1)
+		default:
+			event.EndDate = event.StartDate
+		}
+	}
+	if event.EndDate.Before(event.StartDate) {
+		return Event{}, errors.New("DTEND is before DTSTART")
+	}
+
+	switch status {
+	case "CANCELLED":
+		event.Status = model.Cancelled
+	case "COMPLETED", "DONE":
+		event.Status = model.Done
+	default:
+		event.Status = model.InProgress
+	}
+	return event, nil
+}
+
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCalendar, err)
+	}
+	return lines, nil
+}
+
+func parseProperty(line string) (property, error) {
+	// the value starts at the first colon that is not inside a quoted parameter
+	quoted, split := false, -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			split = i
+			break
+		}
+	}
+	if split < 0 {
+		return property{}, fmt.Errorf("missing value separator in %q", line)
+	}
+
+	parts := strings.Split(line[:split], ";")
+	prop := property{
+		name:   strings.ToUpper(parts[0]),
+		params: map[string]string{},
+		value:  line[split+1:],
+	}
+	for _, param := range parts[1:] {
+		key, val, _ := strings.Cut(param, "=")
+		prop.params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+	}
+	return prop, nil
+}
+
+func parsePropertyTime(prop property) (time.Time, error) {
+	tzid, ok := prop.params["TZID"]
+	if !ok || strings.HasSuffix(prop.value, "Z") || len(prop.value) == len(dateFormat) {
+		return ParseDateTime(prop.value)
+	}
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown time zone %q", tzid)
+	}
+	return time.ParseInLocation(floatingFormat, prop.value, loc)
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	m := durationPattern.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if m[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		duration += time.Duration(n) * unit
+	}
+	if m[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+func unescapeText(value string) string {
+	return strings.NewReplacer(
+		`\\`, `\`,
+		`\;`, ";",
+		`\,`, ",",
+		`\n`, "\n",
+		`\N`, "\n",
+	).Replace(value)
+}
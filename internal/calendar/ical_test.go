@@ -0,0 +1,314 @@
+package calendar
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"com.github/asdsec/planny/internal/model"
+)
+
+func TestContentWriterFoldsLongLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"short", "Standup"},
+		{"exactly one line", strings.Repeat("x", maxLineOctets-len("SUMMARY:"))},
+		{"ascii", strings.Repeat("abcdefghij", 30)},
+		{"multi-byte", strings.Repeat("çğışöü€", 40)},
+		{"emoji", strings.Repeat("📅", 60)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			cw := &contentWriter{w: bufio.NewWriter(&buf)}
+			cw.line("SUMMARY", tt.value)
+			if err := cw.w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			out := buf.String()
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("content line %q does not end with CRLF", out)
+			}
+			physical := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			for i, line := range physical {
+				if len(line) > maxLineOctets {
+					t.Errorf("line %d has %d octets", i, len(line))
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %d does not start with a space", i)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a character: %q", i, line)
+				}
+			}
+
+			lines, err := unfold(strings.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != 1 || lines[0] != "SUMMARY:"+tt.value {
+				t.Errorf("unfolded %q, want %q", lines, "SUMMARY:"+tt.value)
+			}
+		})
+	}
+}
+
+func TestUnfold(t *testing.T) {
+	input := "BEGIN:VEVENT\r\nDESCRIPTION:This is a lo\r\n ng description\r\n\t that exists\r\nEND:VEVENT\n"
+	lines, err := unfold(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"BEGIN:VEVENT", "DESCRIPTION:This is a long description that exists", "END:VEVENT"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("unfolded %q, want %q", lines, want)
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		text    string
+		escaped string
+	}{
+		{"Standup", "Standup"},
+		{"Lunch; with team, maybe", `Lunch\; with team\, maybe`},
+		{`C:\plans`, `C:\\plans`},
+		{"line one\nline two", `line one\nline two`},
+		{`a literal \n`, `a literal \\n`},
+		{`\;,`, `\\\;\,`},
+	}
+	for _, tt := range tests {
+		if got := escapeText(tt.text); got != tt.escaped {
+			t.Errorf("escape %q = %q, want %q", tt.text, got, tt.escaped)
+		}
+		if got := unescapeText(tt.escaped); got != tt.text {
+			t.Errorf("unescape %q = %q, want %q", tt.escaped, got, tt.text)
+		}
+	}
+	if got := escapeText("windows\r\nline"); got != `windows\nline` {
+		t.Errorf("escape CRLF = %q", got)
+	}
+	if got := unescapeText(`upper\Ncase`); got != "upper\ncase" {
+		t.Errorf("unescape \\N = %q", got)
+	}
+}
+
+func TestParseProperty(t *testing.T) {
+	tests := []struct {
+		line   string
+		name   string
+		params map[string]string
+		value  string
+	}{
+		{"summary:Standup", "SUMMARY", map[string]string{}, "Standup"},
+		{"DTSTART;TZID=America/New_York:19970902T090000", "DTSTART",
+			map[string]string{"TZID": "America/New_York"}, "19970902T090000"},
+		{`DESCRIPTION;ALTREP="cid:part1.0001@example.org":The agenda`, "DESCRIPTION",
+			map[string]string{"ALTREP": "cid:part1.0001@example.org"}, "The agenda"},
+		{"URL:https://example.org/a:b", "URL", map[string]string{}, "https://example.org/a:b"},
+	}
+	for _, tt := range tests {
+		prop, err := parseProperty(tt.line)
+		if err != nil {
+			t.Errorf("parse %q: %v", tt.line, err)
+			continue
+		}
+		if prop.name != tt.name || prop.value != tt.value || len(prop.params) != len(tt.params) {
+			t.Errorf("parse %q = %+v", tt.line, prop)
+			continue
+		}
+		for key, value := range tt.params {
+			if prop.params[key] != value {
+				t.Errorf("parse %q: param %s = %q, want %q", tt.line, key, prop.params[key], value)
+			}
+		}
+	}
+	if _, err := parseProperty("SUMMARY"); err == nil {
+		t.Error("parsed a line without a value")
+	}
+}
+
+func TestWriteCalendarRoundTrip(t *testing.T) {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	plans := []model.Plan{
+		{
+			ID:             7,
+			Title:          "Standup; daily, sharp",
+			Description:    strings.Repeat("Notes with ümlauts, commas; and\nnew lines \\ ", 5),
+			StartDate:      start,
+			EndDate:        start.Add(15 * time.Minute),
+			Status:         model.InProgress,
+			RecurrenceRule: "FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=12",
+			ExDates:        []time.Time{start.AddDate(0, 0, 2), start.AddDate(0, 0, 7)},
+		},
+		{
+			ID:           8,
+			ParentID:     7,
+			Title:        "Standup moved",
+			StartDate:    start.AddDate(0, 0, 4).Add(time.Hour),
+			EndDate:      start.AddDate(0, 0, 4).Add(time.Hour + 15*time.Minute),
+			Status:       model.Done,
+			RecurrenceID: start.AddDate(0, 0, 4),
+		},
+		{
+			ID:          9,
+			ExternalUID: "meeting-42@example.org",
+			Title:       "Review",
+			StartDate:   start.Add(48 * time.Hour),
+			EndDate:     start.Add(50 * time.Hour),
+			Status:      model.Cancelled,
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCalendar(&buf, "Planny, test", plans); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `X-WR-CALNAME:Planny\, test`) {
+		t.Error("calendar name is not escaped")
+	}
+	events, err := ParseCalendar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(plans) {
+		t.Fatalf("parsed %d events, want %d", len(events), len(plans))
+	}
+
+	for i, event := range events {
+		plan := plans[i]
+		if event.UID != PlanUID(&plan) {
+			t.Errorf("event %d: uid = %s, want %s", i, event.UID, PlanUID(&plan))
+		}
+		if event.Summary != plan.Title || event.Description != plan.Description {
+			t.Errorf("event %d: text = %q / %q, want %q / %q", i, event.Summary, event.Description, plan.Title,
+				plan.Description)
+		}
+		if !event.StartDate.Equal(plan.StartDate) || !event.EndDate.Equal(plan.EndDate) {
+			t.Errorf("event %d: %s - %s, want %s - %s", i, event.StartDate, event.EndDate, plan.StartDate,
+				plan.EndDate)
+		}
+		if event.Status != plan.Status {
+			t.Errorf("event %d: status = %s, want %s", i, event.Status, plan.Status)
+		}
+		if event.RecurrenceRule != plan.RecurrenceRule {
+			t.Errorf("event %d: rule = %q, want %q", i, event.RecurrenceRule, plan.RecurrenceRule)
+		}
+		if !equalStarts(event.ExDates, plan.ExDates) {
+			t.Errorf("event %d: exdates = %v, want %v", i, event.ExDates, plan.ExDates)
+		}
+		if !event.RecurrenceID.Equal(plan.RecurrenceID) {
+			t.Errorf("event %d: recurrence id = %s, want %s", i, event.RecurrenceID, plan.RecurrenceID)
+		}
+	}
+	// the detached occurrence is published under the UID of its series
+	if events[1].UID != events[0].UID {
+		t.Errorf("detached occurrence uid = %s, want %s", events[1].UID, events[0].UID)
+	}
+}
+
+func TestParseCalendar(t *testing.T) {
+	ny := newYork(t)
+	input := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VTIMEZONE",
+		"TZID:America/New_York",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"UID:series@example.org",
+		"DTSTART;TZID=America/New_York:19970902T090000",
+		"DURATION:PT1H30M",
+		"RRULE:FREQ=WEEKLY;COUNT=10;WKST=MO;BYDAY=TU,TH",
+		"EXDATE;TZID=America/New_York:19970904T090000,19970911T090000",
+		"EXDATE:19970916T130000Z",
+		"SUMMARY:Weekly\\, with\\; escapes",
+		"BEGIN:VALARM",
+		"ACTION:DISPLAY",
+		"SUMMARY:Reminder",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:series@example.org",
+		"RECURRENCE-ID;TZID=America/New_York:19970909T090000",
+		"DTSTART;TZID=America/New_York:19970909T100000",
+		"DTEND;TZID=America/New_York:19970909T110000",
+		"STATUS:CANCELLED",
+		"SUMMARY:Moved",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:holiday@example.org",
+		"DTSTART;VALUE=DATE:19971225",
+		"SUMMARY:Holiday",
+		"END:VEVENT",
+		"BEGIN:VTODO",
+		"SUMMARY:Ignored",
+		"END:VTODO",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	events, err := ParseCalendar(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("parsed %d events, want 3", len(events))
+	}
+
+	series := events[0]
+	start := localTimes(t, ny, "19970902T090000")[0]
+	if !series.StartDate.Equal(start) || !series.EndDate.Equal(start.Add(90*time.Minute)) {
+		t.Errorf("series %s - %s", series.StartDate, series.EndDate)
+	}
+	if series.Summary != "Weekly, with; escapes" {
+		t.Errorf("series summary = %q, the alarm summary must be ignored", series.Summary)
+	}
+	if series.RecurrenceRule != "FREQ=WEEKLY;BYDAY=TU,TH;COUNT=10" {
+		t.Errorf("series rule = %q", series.RecurrenceRule)
+	}
+	exDates := append(localTimes(t, ny, "19970904T090000", "19970911T090000"), time.Date(1997, 9, 16, 13, 0, 0, 0, time.UTC))
+	if !equalStarts(series.ExDates, exDates) {
+		t.Errorf("series exdates = %v, want %v", series.ExDates, exDates)
+	}
+
+	detached := events[1]
+	if want := localTimes(t, ny, "19970909T090000")[0]; !detached.RecurrenceID.Equal(want) {
+		t.Errorf("recurrence id = %s, want %s", detached.RecurrenceID, want)
+	}
+	if detached.Status != model.Cancelled {
+		t.Errorf("detached status = %s", detached.Status)
+	}
+
+	allDay := events[2]
+	if !allDay.StartDate.Equal(time.Date(1997, 12, 25, 0, 0, 0, 0, time.UTC)) ||
+		!allDay.EndDate.Equal(time.Date(1997, 12, 26, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("all day event %s - %s", allDay.StartDate, allDay.EndDate)
+	}
+}
+
+func TestParseCalendarRejectsInvalidDocuments(t *testing.T) {
+	tests := map[string]string{
+		"no calendar": "BEGIN:VEVENT\r\nDTSTART:20240304T090000Z\r\nEND:VEVENT",
+		"unbalanced":  "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20240304T090000Z\r\nEND:VEVENT",
+		"no value":    "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART\r\nEND:VEVENT\r\nEND:VCALENDAR",
+		"no start":    "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:x\r\nEND:VEVENT\r\nEND:VCALENDAR",
+		"end before start": "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20240304T090000Z\r\nDTEND:20240304T080000Z\r\n" +
+			"END:VEVENT\r\nEND:VCALENDAR",
+		"invalid rule": "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20240304T090000Z\r\nRRULE:FREQ=YEARLY\r\n" +
+			"END:VEVENT\r\nEND:VCALENDAR",
+		"unknown time zone": "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART;TZID=Mars/Olympus:20240304T090000\r\n" +
+			"END:VEVENT\r\nEND:VCALENDAR",
+	}
+	for name, input := range tests {
+		if _, err := ParseCalendar(strings.NewReader(input)); !errors.Is(err, ErrInvalidCalendar) {
+			t.Errorf("%s: parse = %v, want %v", name, err, ErrInvalidCalendar)
+		}
+	}
+}
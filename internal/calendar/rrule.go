@@ -0,0 +1,353 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ part of a recurrence rule
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// maxPeriods bounds the number of periods walked by a single expansion
+const maxPeriods = 50000
+
+const (
+	dateTimeFormat = "20060102T150405Z"
+	floatingFormat = "20060102T150405"
+	dateFormat     = "20060102"
+)
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// WeekdayNum is a BYDAY entry, N is the optional ordinal used by monthly rules
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// Rule is the subset of an RFC 5545 RRULE supported by planny
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+}
+
+// ParseRule parses an RRULE value, with or without the "RRULE:" prefix
+func ParseRule(value string) (*Rule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	if value == "" {
+		return nil, fmt.Errorf("%w: empty rule", ErrInvalidRule)
+	}
+
+	rule := &Rule{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok || val == "" {
+			return nil, fmt.Errorf("%w: malformed part %q", ErrInvalidRule, part)
+		}
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = Frequency(strings.ToUpper(val))
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(val)
+			if err == nil && rule.Interval < 1 {
+				err = errors.New("interval must be positive")
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(val)
+			if err == nil && rule.Count < 1 {
+				err = errors.New("count must be positive")
+			}
+		case "UNTIL":
+			rule.Until, err = ParseDateTime(val)
+			if err == nil && len(val) == len(dateFormat) {
+				// a DATE value includes the whole day
+				rule.Until = rule.Until.Add(24*time.Hour - time.Second)
+			}
+		case "BYDAY":
+			rule.ByDay, err = parseByDay(val)
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseByMonthDay(val)
+		case "WKST":
+			if strings.ToUpper(val) != "MO" {
+				err = errors.New("only WKST=MO is supported")
+			}
+		default:
+			err = fmt.Errorf("unsupported part %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRule, err)
+		}
+	}
+
+	switch rule.Freq {
+	case Daily, Weekly, Monthly:
+	case "":
+		return nil, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	default:
+		return nil, fmt.Errorf("%w: unsupported frequency %s", ErrInvalidRule, rule.Freq)
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, fmt.Errorf("%w: COUNT and UNTIL are mutually exclusive", ErrInvalidRule)
+	}
+	if len(rule.ByMonthDay) > 0 && rule.Freq != Monthly {
+		return nil, fmt.Errorf("%w: BYMONTHDAY requires FREQ=MONTHLY", ErrInvalidRule)
+	}
+	for _, wd := range rule.ByDay {
+		if wd.N != 0 && rule.Freq != Monthly {
+			return nil, fmt.Errorf("%w: ordinal BYDAY requires FREQ=MONTHLY", ErrInvalidRule)
+		}
+	}
+	return rule, nil
+}
+
+// String returns the canonical RRULE value without the "RRULE:" prefix
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, 0, len(r.ByDay))
+		for _, wd := range r.ByDay {
+			days = append(days, wd.String())
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, 0, len(r.ByMonthDay))
+		for _, d := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(d))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+FormatDateTime(r.Until))
+	}
+	return strings.Join(parts, ";")
+}
+
+// IsBounded reports whether the rule ends by COUNT or UNTIL
+func (r *Rule) IsBounded() bool {
+	return r.Count > 0 || !r.Until.IsZero()
+}
+
+// Iterate calls fn with every occurrence start of the rule anchored at dtstart,
+// in chronological order, until fn returns false or the rule is exhausted
+func (r *Rule) Iterate(dtstart time.Time, fn func(start time.Time) bool) {
+	emitted := 0
+	for period := 0; period < maxPeriods; period++ {
+		for _, candidate := range r.candidates(dtstart, period) {
+			if candidate.Before(dtstart) {
+				continue
+			}
+			if !r.Until.IsZero() && candidate.After(r.Until) {
+				return
+			}
+			if r.Count > 0 && emitted >= r.Count {
+				return
+			}
+			emitted++
+			if !fn(candidate) {
+				return
+			}
+		}
+	}
+}
+
+// Index returns the zero based position of start within the series, or -1
+// if start is not an occurrence of the rule
+func (r *Rule) Index(dtstart, start time.Time) int {
+	index, i := -1, 0
+	r.Iterate(dtstart, func(t time.Time) bool {
+		if !t.Before(start) {
+			if t.Equal(start) {
+				index = i
+			}
+			return false
+		}
+		i++
+		return true
+	})
+	return index
+}
+
+func (r *Rule) candidates(dtstart time.Time, period int) []time.Time {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), dtstart.Nanosecond(), dtstart.Location())
+	}
+
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		day := dtstart.AddDate(0, 0, period*r.Interval)
+		if len(r.ByDay) == 0 || r.hasWeekday(day.Weekday()) {
+			days = append(days, day)
+		}
+	case Weekly:
+		offset := (int(dtstart.Weekday()) + 6) % 7
+		monday := at(dtstart.Year(), dtstart.Month(), dtstart.Day()-offset).AddDate(0, 0, 7*period*r.Interval)
+		if len(r.ByDay) == 0 {
+			days = append(days, monday.AddDate(0, 0, offset))
+		}
+		for _, wd := range r.ByDay {
+			days = append(days, monday.AddDate(0, 0, (int(wd.Day)+6)%7))
+		}
+	case Monthly:
+		first := time.Date(dtstart.Year(), dtstart.Month()+time.Month(period*r.Interval), 1, 0, 0, 0, 0, dtstart.Location())
+		length := first.AddDate(0, 1, -1).Day()
+		switch {
+		case len(r.ByMonthDay) > 0:
+			for _, d := range r.ByMonthDay {
+				if d < 0 {
+					d = length + d + 1
+				}
+				if d < 1 || d > length {
+					continue
+				}
+				day := at(first.Year(), first.Month(), d)
+				if len(r.ByDay) == 0 || r.hasWeekday(day.Weekday()) {
+					days = append(days, day)
+				}
+			}
+		case len(r.ByDay) > 0:
+			for _, wd := range r.ByDay {
+				for _, d := range monthWeekdays(first, length, wd) {
+					days = append(days, at(first.Year(), first.Month(), d))
+				}
+			}
+		default:
+			if dtstart.Day() <= length {
+				days = append(days, at(first.Year(), first.Month(), dtstart.Day()))
+			}
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	unique := days[:0]
+	for i, day := range days {
+		if i == 0 || !day.Equal(days[i-1]) {
+			unique = append(unique, day)
+		}
+	}
+	return unique
+}
+
+func (r *Rule) hasWeekday(day time.Weekday) bool {
+	for _, wd := range r.ByDay {
+		if wd.Day == day {
+			return true
+		}
+	}
+	return false
+}
+
+func monthWeekdays(first time.Time, length int, wd WeekdayNum) []int {
+	var days []int
+	for d := 1 + (int(wd.Day)-int(first.Weekday())+7)%7; d <= length; d += 7 {
+		days = append(days, d)
+	}
+	switch {
+	case wd.N > 0 && wd.N <= len(days):
+		return days[wd.N-1 : wd.N]
+	case wd.N < 0 && -wd.N <= len(days):
+		return days[len(days)+wd.N : len(days)+wd.N+1]
+	case wd.N == 0:
+		return days
+	default:
+		return nil
+	}
+}
+
+func (wd WeekdayNum) String() string {
+	for name, day := range weekdays {
+		if day == wd.Day {
+			if wd.N != 0 {
+				return strconv.Itoa(wd.N) + name
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+func parseByDay(value string) ([]WeekdayNum, error) {
+	var days []WeekdayNum
+	for _, item := range strings.Split(strings.ToUpper(value), ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("invalid BYDAY %q", item)
+		}
+		day, ok := weekdays[item[len(item)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("invalid BYDAY %q", item)
+		}
+		wd := WeekdayNum{Day: day}
+		if ordinal := item[:len(item)-2]; ordinal != "" {
+			n, err := strconv.Atoi(ordinal)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, fmt.Errorf("invalid BYDAY %q", item)
+			}
+			wd.N = n
+		}
+		days = append(days, wd)
+	}
+	return days, nil
+}
+
+func parseByMonthDay(value string) ([]int, error) {
+	var days []int
+	for _, item := range strings.Split(value, ",") {
+		d, err := strconv.Atoi(item)
+		if err != nil || d == 0 || d < -31 || d > 31 {
+			return nil, fmt.Errorf("invalid BYMONTHDAY %q", item)
+		}
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+// ParseDateTime parses an iCalendar DATE or DATE-TIME value, floating times are read as UTC
+func ParseDateTime(value string) (time.Time, error) {
+	for _, layout := range []string{dateTimeFormat, floatingFormat} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.Parse(dateFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date-time %q", value)
+	}
+	return t, nil
+}
+
+// FormatDateTime formats t as an iCalendar UTC DATE-TIME value
+func FormatDateTime(t time.Time) string {
+	return t.UTC().Format(dateTimeFormat)
+}
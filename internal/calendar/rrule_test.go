@@ -0,0 +1,426 @@
+package calendar
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"com.github/asdsec/planny/internal/model"
+)
+
+// newYork is the time zone of the examples in RFC 5545 section 3.8.5.3
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// localTimes parses floating DATE-TIME values in loc
+func localTimes(t *testing.T, loc *time.Location, values ...string) []time.Time {
+	t.Helper()
+	times := make([]time.Time, len(values))
+	for i, value := range values {
+		var err error
+		if times[i], err = time.ParseInLocation(floatingFormat, value, loc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return times
+}
+
+// iterate returns at most limit occurrences of rule anchored at dtstart
+func iterate(t *testing.T, rule string, dtstart time.Time, limit int) []time.Time {
+	t.Helper()
+	r, err := ParseRule(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []time.Time
+	r.Iterate(dtstart, func(start time.Time) bool {
+		starts = append(starts, start)
+		return len(starts) < limit
+	})
+	return starts
+}
+
+func equalStarts(got, want []time.Time) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !got[i].Equal(want[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"RRULE:freq=weekly;byday=mo,we", "FREQ=WEEKLY;BYDAY=MO,WE"},
+		{"FREQ=WEEKLY;INTERVAL=1;COUNT=10", "FREQ=WEEKLY;COUNT=10"},
+		{"FREQ=WEEKLY;INTERVAL=2;WKST=MO;BYDAY=TU,TH", "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH"},
+		{"FREQ=MONTHLY;BYDAY=1SU,-1SU", "FREQ=MONTHLY;BYDAY=1SU,-1SU"},
+		{"FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13", "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13"},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,-1;COUNT=10", "FREQ=MONTHLY;BYMONTHDAY=1,-1;COUNT=10"},
+		{"FREQ=DAILY;UNTIL=19971224T000000Z", "FREQ=DAILY;UNTIL=19971224T000000Z"},
+		// a DATE value includes the whole day
+		{"FREQ=DAILY;UNTIL=19971224", "FREQ=DAILY;UNTIL=19971224T235959Z"},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.value)
+		if err != nil {
+			t.Errorf("parse %q: %v", tt.value, err)
+			continue
+		}
+		if got := rule.String(); got != tt.want {
+			t.Errorf("parse %q = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseRuleRejectsInvalidRules(t *testing.T) {
+	tests := []string{
+		"",
+		"RRULE:",
+		"FREQ",
+		"INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=19971224T000000Z",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=WEEKLY;WKST=SU",
+	}
+	for _, value := range tests {
+		if _, err := ParseRule(value); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("parse %q = %v, want %v", value, err, ErrInvalidRule)
+		}
+	}
+}
+
+func TestRuleIterateRFC5545(t *testing.T) {
+	ny := newYork(t)
+	tests := []struct {
+		name    string
+		dtstart string
+		rule    string
+		want    []string
+	}{
+		{
+			"daily for 10 occurrences",
+			"19970902T090000", "FREQ=DAILY;COUNT=10",
+			[]string{"19970902T090000", "19970903T090000", "19970904T090000", "19970905T090000", "19970906T090000",
+				"19970907T090000", "19970908T090000", "19970909T090000", "19970910T090000", "19970911T090000"},
+		},
+		{
+			"every 10 days, 5 occurrences",
+			"19970902T090000", "FREQ=DAILY;INTERVAL=10;COUNT=5",
+			[]string{"19970902T090000", "19970912T090000", "19970922T090000", "19971002T090000", "19971012T090000"},
+		},
+		{
+			"weekly for 10 occurrences",
+			"19970902T090000", "FREQ=WEEKLY;COUNT=10",
+			[]string{"19970902T090000", "19970909T090000", "19970916T090000", "19970923T090000", "19970930T090000",
+				"19971007T090000", "19971014T090000", "19971021T090000", "19971028T090000", "19971104T090000"},
+		},
+		{
+			"weekly on Tuesday and Thursday for 10 occurrences",
+			"19970902T090000", "FREQ=WEEKLY;COUNT=10;BYDAY=TU,TH",
+			[]string{"19970902T090000", "19970904T090000", "19970909T090000", "19970911T090000", "19970916T090000",
+				"19970918T090000", "19970923T090000", "19970925T090000", "19970930T090000", "19971002T090000"},
+		},
+		{
+			"every other week on Monday, Wednesday and Friday until December 24, 1997",
+			"19970901T090000", "FREQ=WEEKLY;INTERVAL=2;UNTIL=19971224T000000Z;BYDAY=MO,WE,FR",
+			[]string{"19970901T090000", "19970903T090000", "19970905T090000", "19970915T090000", "19970917T090000",
+				"19970919T090000", "19970929T090000", "19971001T090000", "19971003T090000", "19971013T090000",
+				"19971015T090000", "19971017T090000", "19971027T090000", "19971029T090000", "19971031T090000",
+				"19971110T090000", "19971112T090000", "19971114T090000", "19971124T090000", "19971126T090000",
+				"19971128T090000", "19971208T090000", "19971210T090000", "19971212T090000", "19971222T090000"},
+		},
+		{
+			"every other week on Tuesday and Thursday, for 8 occurrences",
+			"19970902T090000", "FREQ=WEEKLY;INTERVAL=2;COUNT=8;BYDAY=TU,TH",
+			[]string{"19970902T090000", "19970904T090000", "19970916T090000", "19970918T090000", "19970930T090000",
+				"19971002T090000", "19971014T090000", "19971016T090000"},
+		},
+		{
+			"monthly on the first Friday for 10 occurrences",
+			"19970905T090000", "FREQ=MONTHLY;COUNT=10;BYDAY=1FR",
+			[]string{"19970905T090000", "19971003T090000", "19971107T090000", "19971205T090000", "19980102T090000",
+				"19980206T090000", "19980306T090000", "19980403T090000", "19980501T090000", "19980605T090000"},
+		},
+		{
+			"every other month on the first and last Sunday for 10 occurrences",
+			"19970907T090000", "FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU",
+			[]string{"19970907T090000", "19970928T090000", "19971102T090000", "19971130T090000", "19980104T090000",
+				"19980125T090000", "19980301T090000", "19980329T090000", "19980503T090000", "19980531T090000"},
+		},
+		{
+			"monthly on the second-to-last Monday for 6 months",
+			"19970922T090000", "FREQ=MONTHLY;COUNT=6;BYDAY=-2MO",
+			[]string{"19970922T090000", "19971020T090000", "19971117T090000", "19971222T090000", "19980119T090000",
+				"19980216T090000"},
+		},
+		{
+			"monthly on the third-to-the-last day",
+			"19970928T090000", "FREQ=MONTHLY;BYMONTHDAY=-3",
+			[]string{"19970928T090000", "19971029T090000", "19971128T090000", "19971229T090000", "19980129T090000",
+				"19980226T090000"},
+		},
+		{
+			"monthly on the 2nd and 15th for 10 occurrences",
+			"19970902T090000", "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=2,15",
+			[]string{"19970902T090000", "19970915T090000", "19971002T090000", "19971015T090000", "19971102T090000",
+				"19971115T090000", "19971202T090000", "19971215T090000", "19980102T090000", "19980115T090000"},
+		},
+		{
+			"monthly on the first and last day for 10 occurrences",
+			"19970930T090000", "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=1,-1",
+			[]string{"19970930T090000", "19971001T090000", "19971031T090000", "19971101T090000", "19971130T090000",
+				"19971201T090000", "19971231T090000", "19980101T090000", "19980131T090000", "19980201T090000"},
+		},
+		{
+			"every 18 months on the 10th thru 15th for 10 occurrences",
+			"19970910T090000", "FREQ=MONTHLY;INTERVAL=18;COUNT=10;BYMONTHDAY=10,11,12,13,14,15",
+			[]string{"19970910T090000", "19970911T090000", "19970912T090000", "19970913T090000", "19970914T090000",
+				"19970915T090000", "19990310T090000", "19990311T090000", "19990312T090000", "19990313T090000"},
+		},
+		{
+			"every Tuesday, every other month",
+			"19970902T090000", "FREQ=MONTHLY;INTERVAL=2;BYDAY=TU",
+			[]string{"19970902T090000", "19970909T090000", "19970916T090000", "19970923T090000", "19970930T090000",
+				"19971104T090000", "19971111T090000", "19971118T090000", "19971125T090000", "19980106T090000"},
+		},
+		{
+			"every Friday the 13th",
+			"19970902T090000", "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+			[]string{"19980213T090000", "19980313T090000", "19981113T090000", "19990813T090000", "20001013T090000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dtstart := localTimes(t, ny, tt.dtstart)[0]
+			want := localTimes(t, ny, tt.want...)
+			got := iterate(t, tt.rule, dtstart, len(want)+1)
+			if r, _ := ParseRule(tt.rule); !r.IsBounded() {
+				got = got[:min(len(got), len(want))]
+			}
+			if !equalStarts(got, want) {
+				t.Errorf("occurrences = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRuleIterateUntilIsInclusive(t *testing.T) {
+	// RFC 5545: daily until December 24, 1997, the series crosses the end of daylight saving time
+	ny := newYork(t)
+	dtstart := localTimes(t, ny, "19970902T090000")[0]
+	got := iterate(t, "FREQ=DAILY;UNTIL=19971224T000000Z", dtstart, 1000)
+	if len(got) != 113 {
+		t.Fatalf("%d occurrences, want 113", len(got))
+	}
+	if last := localTimes(t, ny, "19971223T090000")[0]; !got[len(got)-1].Equal(last) {
+		t.Errorf("last occurrence = %s, want %s", got[len(got)-1], last)
+	}
+
+	until := time.Date(2024, 3, 6, 9, 0, 0, 0, time.UTC)
+	got = iterate(t, "FREQ=DAILY;UNTIL="+FormatDateTime(until), until.AddDate(0, 0, -2), 10)
+	if len(got) != 3 || !got[2].Equal(until) {
+		t.Errorf("occurrences = %v, want 3 ending at UNTIL", got)
+	}
+}
+
+func TestRuleIterateKeepsWallClockAcrossDST(t *testing.T) {
+	ny := newYork(t)
+	tests := []struct {
+		name    string
+		dtstart string
+		rule    string
+	}{
+		{"daily into daylight saving time", "20240308T090000", "FREQ=DAILY;COUNT=5"},
+		{"daily out of daylight saving time", "20241101T090000", "FREQ=DAILY;COUNT=5"},
+		{"weekly into daylight saving time", "20240304T090000", "FREQ=WEEKLY;COUNT=3;BYDAY=MO,FR"},
+		{"monthly out of daylight saving time", "20241015T090000", "FREQ=MONTHLY;COUNT=3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dtstart := localTimes(t, ny, tt.dtstart)[0]
+			got := iterate(t, tt.rule, dtstart, 10)
+			offsets := map[int]bool{}
+			for _, start := range got {
+				if start.Hour() != 9 || start.Minute() != 0 {
+					t.Errorf("occurrence %s is not at 09:00 local time", start)
+				}
+				_, offset := start.Zone()
+				offsets[offset] = true
+			}
+			if len(offsets) != 2 {
+				t.Errorf("occurrences %v do not cross a DST change", got)
+			}
+		})
+	}
+}
+
+func TestRuleIterateMonthEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		dtstart string
+		rule    string
+		want    []string
+	}{
+		{
+			// months without the day are skipped rather than overflowing into the next one
+			"monthly on the 31st",
+			"20240131T090000", "FREQ=MONTHLY;COUNT=5",
+			[]string{"20240131T090000", "20240331T090000", "20240531T090000", "20240731T090000", "20240831T090000"},
+		},
+		{
+			"monthly on the 30th over February",
+			"20240130T090000", "FREQ=MONTHLY;COUNT=3;BYMONTHDAY=30",
+			[]string{"20240130T090000", "20240330T090000", "20240430T090000"},
+		},
+		{
+			"monthly on the last day",
+			"20240131T090000", "FREQ=MONTHLY;COUNT=4;BYMONTHDAY=-1",
+			[]string{"20240131T090000", "20240229T090000", "20240331T090000", "20240430T090000"},
+		},
+		{
+			"monthly on the fifth Friday",
+			"20240301T090000", "FREQ=MONTHLY;COUNT=3;BYDAY=5FR",
+			[]string{"20240329T090000", "20240531T090000", "20240830T090000"},
+		},
+		{
+			"leap day",
+			"20240229T090000", "FREQ=MONTHLY;COUNT=3;BYMONTHDAY=29",
+			[]string{"20240229T090000", "20240329T090000", "20240429T090000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dtstart := localTimes(t, time.UTC, tt.dtstart)[0]
+			want := localTimes(t, time.UTC, tt.want...)
+			if got := iterate(t, tt.rule, dtstart, len(want)+1); !equalStarts(got, want) {
+				t.Errorf("occurrences = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRuleIndex(t *testing.T) {
+	dtstart := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	rule, err := ParseRule("FREQ=WEEKLY;BYDAY=MO,WE,FR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		start time.Time
+		index int
+	}{
+		{dtstart, 0},
+		{dtstart.AddDate(0, 0, 2), 1},
+		{dtstart.AddDate(0, 0, 7), 3},
+		{dtstart.AddDate(0, 0, 1), -1},
+		{dtstart.Add(time.Hour), -1},
+		{dtstart.AddDate(0, 0, -2), -1},
+	}
+	for _, tt := range tests {
+		if got := rule.Index(dtstart, tt.start); got != tt.index {
+			t.Errorf("index of %s = %d, want %d", tt.start, got, tt.index)
+		}
+	}
+}
+
+func TestExpandExDates(t *testing.T) {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	excluded := start.AddDate(0, 0, 7)
+	plan := model.Plan{
+		ID:             1,
+		StartDate:      start,
+		EndDate:        start.Add(time.Hour),
+		RecurrenceRule: "FREQ=WEEKLY;COUNT=4",
+		ExDates:        []time.Time{excluded},
+	}
+
+	occurrences, err := Expand(plan, start, start.AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an excluded occurrence still counts towards COUNT
+	want := []time.Time{start, start.AddDate(0, 0, 14), start.AddDate(0, 0, 21)}
+	if len(occurrences) != len(want) {
+		t.Fatalf("%d occurrences, want %d", len(occurrences), len(want))
+	}
+	for i, occurrence := range occurrences {
+		if !occurrence.StartDate.Equal(want[i]) || !occurrence.RecurrenceID.Equal(want[i]) {
+			t.Errorf("occurrence %d starts at %s with recurrence id %s, want %s", i,
+				occurrence.StartDate, occurrence.RecurrenceID, want[i])
+		}
+		if !occurrence.EndDate.Equal(want[i].Add(time.Hour)) {
+			t.Errorf("occurrence %d ends at %s, want %s", i, occurrence.EndDate, want[i].Add(time.Hour))
+		}
+		if occurrence.ID != plan.ID {
+			t.Errorf("occurrence %d has id %d, want %d", i, occurrence.ID, plan.ID)
+		}
+	}
+
+	if IsOccurrence(plan, excluded) {
+		t.Error("excluded start is an occurrence")
+	}
+	if !IsOccurrence(plan, want[1]) {
+		t.Error("start is not an occurrence")
+	}
+	if IsOccurrence(plan, start.AddDate(0, 0, 28)) {
+		t.Error("start after COUNT is an occurrence")
+	}
+}
+
+func TestExpandWindow(t *testing.T) {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	plan := model.Plan{
+		StartDate:      start,
+		EndDate:        start.Add(2 * time.Hour),
+		RecurrenceRule: "FREQ=DAILY",
+	}
+	// the occurrence running over from is included, the one starting at to is not
+	from := start.AddDate(0, 0, 2).Add(time.Hour)
+	to := start.AddDate(0, 0, 5)
+	occurrences, err := Expand(plan, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(occurrences) != 3 {
+		t.Fatalf("%d occurrences, want 3", len(occurrences))
+	}
+	if !occurrences[0].StartDate.Equal(start.AddDate(0, 0, 2)) {
+		t.Errorf("first occurrence starts at %s", occurrences[0].StartDate)
+	}
+
+	_, end, err := Span(model.Plan{
+		StartDate:      start,
+		EndDate:        start.Add(2 * time.Hour),
+		RecurrenceRule: "FREQ=DAILY;COUNT=3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.AddDate(0, 0, 2).Add(2 * time.Hour); !end.Equal(want) {
+		t.Errorf("span ends at %s, want %s", end, want)
+	}
+}
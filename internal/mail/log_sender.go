@@ -0,0 +1,24 @@
+package mail
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LogSender writes emails to the log instead of delivering them, it is meant for development
+type LogSender struct{}
+
+// NewLogSender creates a new LogSender
+func NewLogSender() Sender {
+	return &LogSender{}
+}
+
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	log.Info().
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Str("body", msg.Body).
+		Msg("email")
+	return nil
+}
@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+type CalendarFeed struct {
+	ID        uint
+	UserID    uint
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// LoginAttempt counts the recent failed logins of an account or a client address
+type LoginAttempt struct {
+	Key         string
+	Failures    uint
+	LockedUntil time.Time
+	UpdatedAt   time.Time
+}
+
+// IsLocked tells whether logins are refused at t
+func (a *LoginAttempt) IsLocked(t time.Time) bool {
+	return t.Before(a.LockedUntil)
+}
@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// TokenPurpose tells what a one time token can be exchanged for
+type TokenPurpose string
+
+const (
+	PasswordResetToken TokenPurpose = "password_reset"
+	MagicLinkToken     TokenPurpose = "magic_link"
+)
+
+type OneTimeToken struct {
+	ID        uint
+	UserID    uint
+	Purpose   TokenPurpose
+	UsedAt    time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+type PersonalAccessToken struct {
+	ID         uint
+	UserID     uint
+	Username   string
+	Name       string
+	Scopes     []string
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+// HasScope reports whether the token grants scope
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -13,13 +13,36 @@
 )
 
 type Plan struct {
-	ID          uint
-	Title       string
-	Description string
-	StartDate   time.Time
-	EndDate     time.Time
-	Status      Status
-	UserID      uint
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID             uint
+	Title          string
+	Description    string
+	StartDate      time.Time
+	EndDate        time.Time
+	Status         Status
+	RecurrenceRule string
+	ExDates        []time.Time
+	ParentID       uint
+	RecurrenceID   time.Time
+	ExternalUID    string
+	AutoComplete   bool
+	ItemCount      int
+	DoneItemCount  int
+	Version        uint
+	Tags           []Tag
+	UserID         uint
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// IsRecurring reports whether the plan is the master of a recurring series
+func (p *Plan) IsRecurring() bool {
+	return p.RecurrenceRule != ""
+}
+
+// Progress returns the percentage of checked items, zero for plans without items
+func (p *Plan) Progress() int {
+	if p.ItemCount == 0 {
+		return 0
+	}
+	return p.DoneItemCount * 100 / p.ItemCount
 }
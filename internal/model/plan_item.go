@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+type PlanItem struct {
+	ID        uint
+	PlanID    uint
+	Title     string
+	Position  int
+	IsDone    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
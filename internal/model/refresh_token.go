@@ -0,0 +1,19 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type RefreshToken struct {
+	ID        uint
+	SessionID uuid.UUID
+	UsedAt    time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// IsUsed reports whether the token has already been exchanged for a new one
+func (t *RefreshToken) IsUsed() bool {
+	return !t.UsedAt.IsZero()
+}
@@ -6,13 +6,14 @@
 )
 
 type Session struct {
-	ID           uuid.UUID
-	UserID       uint
-	Username     string
-	RefreshToken string
-	UserAgent    string
-	ClientIp     string
-	ExpiresAt    time.Time
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID         uuid.UUID
+	UserID     uint
+	Username   string
+	UserAgent  string
+	ClientIp   string
+	ExpiresAt  time.Time
+	IsBlocked  bool
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+type Tag struct {
+	ID        uint
+	Name      string
+	Color     string
+	UserID    uint
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
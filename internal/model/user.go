@@ -3,12 +3,16 @@
 import "time"
 
 type User struct {
-	ID        uint      `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Password  string    `json:"password"`
-	UpdatedAt time.Time `json:"updated_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID              uint      `json:"id"`
+	Username        string    `json:"username"`
+	Email           string    `json:"email"`
+	FirstName       string    `json:"first_name"`
+	LastName        string    `json:"last_name"`
+	Password        string    `json:"password"`
+	EmailVerified   bool      `json:"email_verified"`
+	TotpEnabled     bool      `json:"totp_enabled"`
+	TotpSecret      string    `json:"-"`
+	TotpLastCounter int64     `json:"-"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	CreatedAt       time.Time `json:"created_at"`
 }
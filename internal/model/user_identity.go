@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// UserIdentity links a user to its account at an OpenID Provider
+type UserIdentity struct {
+	ID        uint
+	UserID    uint
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
@@ -0,0 +1,121 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// clockSkew is the difference tolerated between the clocks of the provider and the server
+const clockSkew = time.Minute
+
+// signingMethods are the algorithms ID tokens may be signed with, symmetric algorithms and none
+// are rejected since the client secret is not a signing key
+var signingMethods = []string{"RS256", "ES256", "EdDSA"}
+
+// Claims are the verified claims of an ID token
+type Claims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	GivenName         string `json:"given_name"`
+	FamilyName        string `json:"family_name"`
+	PreferredUsername string `json:"preferred_username"`
+
+	Issuer          string   `json:"iss"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Nonce           string   `json:"nonce"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+}
+
+// UnmarshalJSON accepts email_verified sent as a string, which some providers do
+func (c *Claims) UnmarshalJSON(b []byte) error {
+	type plain Claims
+	claims := struct {
+		*plain
+		EmailVerified interface{} `json:"email_verified"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return err
+	}
+	switch verified := claims.EmailVerified.(type) {
+	case bool:
+		c.EmailVerified = verified
+	case string:
+		c.EmailVerified = verified == "true"
+	}
+	return nil
+}
+
+// Valid checks the time claims, it is called by the jwt parser once the signature is verified
+func (c *Claims) Valid() error {
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return errors.New("token has expired")
+	}
+	if now.Add(clockSkew).Before(time.Unix(c.IssuedAt, 0)) {
+		return errors.New("token is issued in the future")
+	}
+	return nil
+}
+
+// verifyIDToken checks an ID token as described in OpenID Connect Core 3.1.3.7
+func (p *Provider) verifyIDToken(ctx context.Context, meta *metadata, raw, nonce string) (*Claims, error) {
+	parser := &jwt.Parser{ValidMethods: signingMethods}
+	var claims Claims
+	_, err := parser.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, id)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != meta.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, fmt.Errorf("%w: token is not issued for this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fmt.Errorf("%w: token is not authorized for this client", ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: token lacks a subject", ErrInvalidIDToken)
+	}
+	return &claims, nil
+}
+
+// audience is the aud claim which is either a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	httpTimeout   = 10 * time.Second
+	maxBodySize   = 1 << 20
+)
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// providerName keeps names safe to use in URLs
+var providerName = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// Config describes an OpenID Provider a student can sign in with
+type Config struct {
+	// Name identifies the provider in URLs and linked identities, e.g. "google"
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// metadata is the part of the discovery document the relying party needs
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider runs the authorization code flow with PKCE against an OpenID Provider. The discovery
+// document is fetched on first use so that an unreachable provider does not stop the server.
+type Provider struct {
+	config      Config
+	redirectURL string
+	client      *http.Client
+
+	mu   sync.Mutex
+	meta *metadata
+	keys *keySet
+}
+
+// NewProvider creates a new Provider, redirectURL is where the provider sends students back to
+func NewProvider(config Config, redirectURL string) (*Provider, error) {
+	if config.Name == "" || config.Issuer == "" || config.ClientID == "" {
+		return nil, errors.New("provider requires a name, an issuer and a client id")
+	}
+	if !providerName.MatchString(config.Name) {
+		return nil, errors.New("provider name may only contain lowercase letters, digits, _ and -")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{
+		config:      config,
+		redirectURL: redirectURL,
+		client:      &http.Client{Timeout: httpTimeout},
+	}, nil
+}
+
+// LoadProviders reads a JSON array of provider configs, redirectURL returns the redirect URL of
+// the named provider
+func LoadProviders(path string, redirectURL func(name string) string) (map[string]*Provider, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read providers: %w", err)
+	}
+	var configs []Config
+	if err = json.Unmarshal(b, &configs); err != nil {
+		return nil, fmt.Errorf("invalid providers: %w", err)
+	}
+	providers := map[string]*Provider{}
+	for _, config := range configs {
+		if _, ok := providers[config.Name]; ok {
+			return nil, fmt.Errorf("invalid providers: duplicated name %q", config.Name)
+		}
+		provider, err := NewProvider(config, redirectURL(config.Name))
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", config.Name, err)
+		}
+		providers[config.Name] = provider
+	}
+	return providers, nil
+}
+
+// Name returns the name of the provider
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta metadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+discoveryPath, &meta); err != nil {
+		return nil, fmt.Errorf("cannot discover provider: %w", err)
+	}
+	// the issuer of the document has to be the one configured, see OpenID Connect Discovery 4.3
+	if meta.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovered issuer %q does not match %q", meta.Issuer, p.config.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks endpoints")
+	}
+	p.meta = &meta
+	p.keys = newKeySet(meta.JWKSURI, p.getJSON)
+	return p.meta, nil
+}
+
+// AuthCodeURL returns the URL of the provider the student is sent to. The code challenge is
+// derived from verifier, which has to be kept until the code is exchanged.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.redirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return meta.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for tokens and returns the verified claims of the ID
+// token, nonce is the one sent with AuthCodeURL
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.config.ClientID)
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot exchange code: %w", err)
+	}
+	defer rsp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(io.LimitReader(rsp.Body, maxBodySize)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot exchange code: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response lacks an id token")
+	}
+	return p.verifyIDToken(ctx, meta, token.IDToken, nonce)
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, rsp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(rsp.Body, maxBodySize)).Decode(v)
+}
@@ -0,0 +1,206 @@
+package paseto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Headers of the PASETO v4 purposes
+const (
+	LocalHeader  = "v4.local."
+	PublicHeader = "v4.public."
+)
+
+const (
+	// KeySize is the size of a v4.local key
+	KeySize = 32
+
+	nonceSize = 32
+	macSize   = 32
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid paseto token")
+	ErrInvalidKey   = errors.New("invalid paseto key")
+)
+
+// encoding is strict so that a token has exactly one encoding, lax decoding would ignore the
+// unused bits of the last character
+var encoding = base64.RawURLEncoding.Strict()
+
+// Encrypt returns a v4.local token of message, it is encrypted with XChaCha20 and authenticated
+// with a keyed BLAKE2b. The footer is sent in the clear but authenticated, the implicit
+// assertion is authenticated without being sent.
+func Encrypt(key, message, footer, implicit []byte) (string, error) {
+	if len(key) != KeySize {
+		return "", ErrInvalidKey
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return encrypt(key, nonce, message, footer, implicit)
+}
+
+func encrypt(key, nonce, message, footer, implicit []byte) (string, error) {
+	encryptionKey, counterNonce, authKey, err := splitKey(key, nonce)
+	if err != nil {
+		return "", err
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(message))
+	cipher.XORKeyStream(ciphertext, message)
+
+	mac, err := keyedHash(authKey, macSize, pae([]byte(LocalHeader), nonce, ciphertext, footer, implicit))
+	if err != nil {
+		return "", err
+	}
+	body := append(append(append([]byte{}, nonce...), ciphertext...), mac...)
+	return token(LocalHeader, body, footer), nil
+}
+
+// Decrypt returns the message of a v4.local token after checking that it has not been tampered
+// with and that it carries the expected footer
+func Decrypt(key []byte, token string, footer, implicit []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	body, err := parse(LocalHeader, token, footer)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < nonceSize+macSize {
+		return nil, ErrInvalidToken
+	}
+	nonce := body[:nonceSize]
+	ciphertext := body[nonceSize : len(body)-macSize]
+	mac := body[len(body)-macSize:]
+
+	encryptionKey, counterNonce, authKey, err := splitKey(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := keyedHash(authKey, macSize, pae([]byte(LocalHeader), nonce, ciphertext, footer, implicit))
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(mac, expected) != 1 {
+		return nil, ErrInvalidToken
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(message, ciphertext)
+	return message, nil
+}
+
+// splitKey derives the encryption key, the XChaCha20 nonce and the authentication key of a token
+// from the shared key and the token nonce
+func splitKey(key, nonce []byte) ([]byte, []byte, []byte, error) {
+	derived, err := keyedHash(key, 56, append([]byte("paseto-encryption-key"), nonce...))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	authKey, err := keyedHash(key, 32, append([]byte("paseto-auth-key-for-aead"), nonce...))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return derived[:32], derived[32:], authKey, nil
+}
+
+func keyedHash(key []byte, size int, message []byte) ([]byte, error) {
+	h, err := blake2b.New(size, key)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(message)
+	return h.Sum(nil), nil
+}
+
+// Sign returns a v4.public token of message signed with Ed25519, the message itself is only
+// encoded and can be read by anyone
+func Sign(key ed25519.PrivateKey, message, footer, implicit []byte) (string, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return "", ErrInvalidKey
+	}
+	signature := ed25519.Sign(key, pae([]byte(PublicHeader), message, footer, implicit))
+	body := append(append([]byte{}, message...), signature...)
+	return token(PublicHeader, body, footer), nil
+}
+
+// Verify returns the message of a v4.public token after checking its signature and footer
+func Verify(key ed25519.PublicKey, token string, footer, implicit []byte) ([]byte, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, ErrInvalidKey
+	}
+	body, err := parse(PublicHeader, token, footer)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < ed25519.SignatureSize {
+		return nil, ErrInvalidToken
+	}
+	message := body[:len(body)-ed25519.SignatureSize]
+	signature := body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(key, pae([]byte(PublicHeader), message, footer, implicit), signature) {
+		return nil, ErrInvalidToken
+	}
+	return message, nil
+}
+
+func token(header string, body, footer []byte) string {
+	token := header + encoding.EncodeToString(body)
+	if len(footer) > 0 {
+		token += "." + encoding.EncodeToString(footer)
+	}
+	return token
+}
+
+// parse checks the header and footer of a token and returns its decoded body
+func parse(header, token string, footer []byte) ([]byte, error) {
+	if !strings.HasPrefix(token, header) {
+		return nil, ErrInvalidToken
+	}
+	encodedBody, encodedFooter, _ := strings.Cut(token[len(header):], ".")
+	actualFooter, err := encoding.DecodeString(encodedFooter)
+	if err != nil || subtle.ConstantTimeCompare(actualFooter, footer) != 1 {
+		return nil, ErrInvalidToken
+	}
+	body, err := encoding.DecodeString(encodedBody)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return body, nil
+}
+
+// pae is the pre-authentication encoding of PASETO, it makes the concatenation of the pieces
+// unambiguous before they are authenticated
+func pae(pieces ...[]byte) []byte {
+	var b bytes.Buffer
+	writeLength := func(n int) {
+		var length [8]byte
+		binary.LittleEndian.PutUint64(length[:], uint64(n)&^(1<<63))
+		b.Write(length[:])
+	}
+	writeLength(len(pieces))
+	for _, piece := range pieces {
+		writeLength(len(piece))
+		b.Write(piece)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,213 @@
+package paseto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Keys and messages of the PASETO v4 test vectors, see
+// https://github.com/paseto-standard/test-vectors/blob/master/v4.json
+const (
+	vectorLocalKey   = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+	vectorSecretKey  = "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	vectorPublicKey  = "1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	vectorSecret     = `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`
+	vectorSigned     = `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`
+	vectorFooter     = `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`
+	vectorAssertion  = `{"test-vector":"4-E-7"}`
+	vectorZeroNonce  = "0000000000000000000000000000000000000000000000000000000000000000"
+	vectorFixedNonce = "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLocalVectors(t *testing.T) {
+	key := decodeHex(t, vectorLocalKey)
+	tests := []struct {
+		name  string
+		nonce string
+		token string
+	}{
+		{
+			name:  "4-E-1",
+			nonce: vectorZeroNonce,
+			token: "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg",
+		},
+		{
+			name:  "4-E-3",
+			nonce: vectorFixedNonce,
+			token: "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := encrypt(key, decodeHex(t, tt.nonce), []byte(vectorSecret), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.token {
+				t.Errorf("encrypt = %s, want %s", token, tt.token)
+			}
+			message, err := Decrypt(key, tt.token, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(message) != vectorSecret {
+				t.Errorf("decrypt = %s, want %s", message, vectorSecret)
+			}
+		})
+	}
+}
+
+func TestPublicVectors(t *testing.T) {
+	secretKey := ed25519.PrivateKey(decodeHex(t, vectorSecretKey))
+	publicKey := ed25519.PublicKey(decodeHex(t, vectorPublicKey))
+	if !bytes.Equal(secretKey.Public().(ed25519.PublicKey), publicKey) {
+		t.Fatal("public key does not belong to the secret key")
+	}
+	// 4-S-1
+	want := "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA"
+	token, err := Sign(secretKey, []byte(vectorSigned), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != want {
+		t.Errorf("sign = %s, want %s", token, want)
+	}
+	message, err := Verify(publicKey, want, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message) != vectorSigned {
+		t.Errorf("verify = %s, want %s", message, vectorSigned)
+	}
+}
+
+func TestLocalRejects(t *testing.T) {
+	key := decodeHex(t, vectorLocalKey)
+	footer, implicit := []byte(vectorFooter), []byte(vectorAssertion)
+	token, err := encrypt(key, decodeHex(t, vectorFixedNonce), []byte(vectorSecret), footer, implicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message, err := Decrypt(key, token, footer, implicit); err != nil || string(message) != vectorSecret {
+		t.Fatalf("decrypt = %s, %v", message, err)
+	}
+
+	otherKey := bytes.Repeat([]byte{1}, KeySize)
+	publicToken, err := Sign(ed25519.PrivateKey(decodeHex(t, vectorSecretKey)), []byte(vectorSigned), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		key      []byte
+		token    string
+		footer   []byte
+		implicit []byte
+	}{
+		{"wrong key", otherKey, token, footer, implicit},
+		{"wrong footer", key, token, []byte(`{"kid":"other"}`), implicit},
+		{"missing footer", key, token, nil, implicit},
+		{"wrong implicit assertion", key, token, footer, []byte(`{"test-vector":"other"}`)},
+		{"tampered body", key, tamper(token, len(LocalHeader)+nonceSize), footer, implicit},
+		{"tampered mac", key, tamper(token, strings.LastIndex(token, ".")-2), footer, implicit},
+		{"non-canonical encoding", key, tamper(token, strings.LastIndex(token, ".")-1), footer, implicit},
+		{"public token", key, publicToken, nil, nil},
+		{"other version", key, "v3.local." + token[len(LocalHeader):], footer, implicit},
+		{"truncated", key, token[:len(LocalHeader)+10], nil, nil},
+		{"invalid encoding", key, LocalHeader + "!!!", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.key, tt.token, tt.footer, tt.implicit); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("decrypt error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+
+	if _, err := Decrypt(key[:16], token, footer, implicit); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("decrypt with short key error = %v, want %v", err, ErrInvalidKey)
+	}
+}
+
+func TestPublicRejects(t *testing.T) {
+	secretKey := ed25519.PrivateKey(decodeHex(t, vectorSecretKey))
+	publicKey := ed25519.PublicKey(decodeHex(t, vectorPublicKey))
+	footer, implicit := []byte(vectorFooter), []byte(`{"test-vector":"4-S-3"}`)
+	token, err := Sign(secretKey, []byte(vectorSigned), footer, implicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message, err := Verify(publicKey, token, footer, implicit); err != nil || string(message) != vectorSigned {
+		t.Fatalf("verify = %s, %v", message, err)
+	}
+
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localToken, err := Encrypt(decodeHex(t, vectorLocalKey), []byte(vectorSecret), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		key      ed25519.PublicKey
+		token    string
+		footer   []byte
+		implicit []byte
+	}{
+		{"wrong key", otherKey, token, footer, implicit},
+		{"wrong footer", publicKey, token, []byte(`{"kid":"other"}`), implicit},
+		{"wrong implicit assertion", publicKey, token, footer, nil},
+		{"tampered message", publicKey, tamper(token, len(PublicHeader)+2), footer, implicit},
+		{"tampered signature", publicKey, tamper(token, strings.LastIndex(token, ".")-2), footer, implicit},
+		{"non-canonical encoding", publicKey, tamper(token, strings.LastIndex(token, ".")-1), footer, implicit},
+		{"local token", publicKey, localToken, nil, nil},
+		{"other version", publicKey, "v2.public." + token[len(PublicHeader):], footer, implicit},
+		{"truncated", publicKey, PublicHeader + "AAAA", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Verify(tt.key, tt.token, tt.footer, tt.implicit); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("verify error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestEncryptUsesFreshNonces(t *testing.T) {
+	key := decodeHex(t, vectorLocalKey)
+	first, err := Encrypt(key, []byte(vectorSecret), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Encrypt(key, []byte(vectorSecret), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("two tokens of the same message are equal")
+	}
+}
+
+// tamper flips a character of the token at i to another valid base64url character
+func tamper(token string, i int) string {
+	replacement := byte('A')
+	if token[i] == 'A' {
+		replacement = 'B'
+	}
+	return token[:i] + string(replacement) + token[i+1:]
+}
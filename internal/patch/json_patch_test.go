@@ -0,0 +1,327 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// equalJSON reports whether a and b hold the same JSON value regardless of member order
+func equalJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var x, y interface{}
+	if err := json.Unmarshal(a, &x); err != nil {
+		t.Fatalf("invalid JSON %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b, err)
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func TestJSONPatchRFC6902(t *testing.T) {
+	// the examples of RFC 6902 Appendix A, err names the sentinel a failing example wraps
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error
+	}{
+		{
+			"A.1 adding an object member",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux"}]`,
+			`{"baz":"qux","foo":"bar"}`, nil,
+		},
+		{
+			"A.2 adding an array element",
+			`{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			`{"foo":["bar","qux","baz"]}`, nil,
+		},
+		{
+			"A.3 removing an object member",
+			`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`,
+			`{"foo":"bar"}`, nil,
+		},
+		{
+			"A.4 removing an array element",
+			`{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`,
+			`{"foo":["bar","baz"]}`, nil,
+		},
+		{
+			"A.5 replacing a value",
+			`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`,
+			`{"baz":"boo","foo":"bar"}`, nil,
+		},
+		{
+			"A.6 moving a value",
+			`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil,
+		},
+		{
+			"A.7 moving an array element",
+			`{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`, nil,
+		},
+		{
+			"A.8 testing a value: success",
+			`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`, nil,
+		},
+		{
+			"A.9 testing a value: error",
+			`{"baz":"qux"}`,
+			`[{"op":"test","path":"/baz","value":"bar"}]`,
+			"", ErrTestFailed,
+		},
+		{
+			"A.10 adding a nested member object",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`, nil,
+		},
+		{
+			"A.11 ignoring unrecognized elements",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			`{"foo":"bar","baz":"qux"}`, nil,
+		},
+		{
+			"A.12 adding to a nonexistent target",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"A.14 ~ escape ordering",
+			`{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":10}]`,
+			`{"/":9,"~1":10}`, nil,
+		},
+		{
+			"A.15 comparing strings and numbers",
+			`{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":"10"}]`,
+			"", ErrTestFailed,
+		},
+		{
+			"A.16 adding an array value",
+			`{"foo":["bar"]}`,
+			`[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			`{"foo":["bar",["abc","def"]]}`, nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := JSONPatch([]byte(tt.doc), []byte(tt.patch))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("patch = %s, %v, want %v", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalJSON(t, got, []byte(tt.want)) {
+				t.Errorf("patch = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchOperations(t *testing.T) {
+	doc := `{"title":"Standup","tags":["work","daily"],"a/b":1,"m~n":2,"nested":{"x":1}}`
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+		err   error
+	}{
+		{
+			"copy an object member",
+			`[{"op":"copy","from":"/title","path":"/description"}]`,
+			`{"title":"Standup","description":"Standup","tags":["work","daily"],"a/b":1,"m~n":2,"nested":{"x":1}}`, nil,
+		},
+		{
+			"copy does not share the value",
+			`[{"op":"copy","from":"/nested","path":"/copied"},{"op":"replace","path":"/copied/x","value":2}]`,
+			`{"title":"Standup","tags":["work","daily"],"a/b":1,"m~n":2,"nested":{"x":1},"copied":{"x":2}}`, nil,
+		},
+		{
+			"copy an array element to the end",
+			`[{"op":"copy","from":"/tags/0","path":"/tags/-"}]`,
+			`{"title":"Standup","tags":["work","daily","work"],"a/b":1,"m~n":2,"nested":{"x":1}}`, nil,
+		},
+		{
+			"move an object member",
+			`[{"op":"move","from":"/title","path":"/name"}]`,
+			`{"name":"Standup","tags":["work","daily"],"a/b":1,"m~n":2,"nested":{"x":1}}`, nil,
+		},
+		{
+			"move to the same location",
+			`[{"op":"move","from":"/nested","path":"/nested"}]`,
+			doc, nil,
+		},
+		{
+			"move into a child of itself",
+			`[{"op":"move","from":"/nested","path":"/nested/y"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"move a missing value",
+			`[{"op":"move","from":"/missing","path":"/title"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"append with -",
+			`[{"op":"add","path":"/tags/-","value":"team"}]`,
+			`{"title":"Standup","tags":["work","daily","team"],"a/b":1,"m~n":2,"nested":{"x":1}}`, nil,
+		},
+		{
+			"add at the array end index",
+			`[{"op":"add","path":"/tags/2","value":"team"}]`,
+			`{"title":"Standup","tags":["work","daily","team"],"a/b":1,"m~n":2,"nested":{"x":1}}`, nil,
+		},
+		{
+			"add past the array end",
+			`[{"op":"add","path":"/tags/3","value":"team"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"remove with -",
+			`[{"op":"remove","path":"/tags/-"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"test with -",
+			`[{"op":"test","path":"/tags/-","value":"daily"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"index with a leading zero",
+			`[{"op":"remove","path":"/tags/01"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"negative index",
+			`[{"op":"remove","path":"/tags/-1"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"escaped slash",
+			`[{"op":"replace","path":"/a~1b","value":3}]`,
+			`{"title":"Standup","tags":["work","daily"],"a/b":3,"m~n":2,"nested":{"x":1}}`, nil,
+		},
+		{
+			"escaped tilde",
+			`[{"op":"remove","path":"/m~0n"}]`,
+			`{"title":"Standup","tags":["work","daily"],"a/b":1,"nested":{"x":1}}`, nil,
+		},
+		{
+			"add null keeps the member",
+			`[{"op":"add","path":"/title","value":null}]`,
+			`{"title":null,"tags":["work","daily"],"a/b":1,"m~n":2,"nested":{"x":1}}`, nil,
+		},
+		{
+			"test null",
+			`[{"op":"add","path":"/title","value":null},{"op":"test","path":"/title","value":null}]`,
+			`{"title":null,"tags":["work","daily"],"a/b":1,"m~n":2,"nested":{"x":1}}`, nil,
+		},
+		{
+			"add without a value",
+			`[{"op":"add","path":"/title"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"replace a missing member",
+			`[{"op":"replace","path":"/missing","value":1}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"replace the whole document",
+			`[{"op":"replace","path":"","value":{"title":"Review"}}]`,
+			`{"title":"Review"}`, nil,
+		},
+		{
+			"add the whole document",
+			`[{"op":"add","path":"","value":{"title":"Review"}}]`,
+			`{"title":"Review"}`, nil,
+		},
+		{
+			"path without a leading slash",
+			`[{"op":"remove","path":"title"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"unknown operation",
+			`[{"op":"merge","path":"/title","value":"x"}]`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"not an array",
+			`{"op":"remove","path":"/title"}`,
+			"", ErrInvalidPatch,
+		},
+		{
+			"a failing operation discards the earlier ones",
+			`[{"op":"remove","path":"/title"},{"op":"test","path":"/tags/0","value":"home"}]`,
+			"", ErrTestFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := JSONPatch([]byte(doc), []byte(tt.patch))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("patch = %s, %v, want %v", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalJSON(t, got, []byte(tt.want)) {
+				t.Errorf("patch = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		tokens  []string
+	}{
+		{"", nil},
+		{"/", []string{""}},
+		{"/foo/0", []string{"foo", "0"}},
+		{"/a~1b", []string{"a/b"}},
+		{"/m~0n", []string{"m~n"}},
+		// ~01 is ~1 and not /, RFC 6901 unescapes ~1 before ~0
+		{"/~01", []string{"~1"}},
+		{"/~10", []string{"/0"}},
+	}
+	for _, tt := range tests {
+		tokens, err := parsePointer(tt.pointer)
+		if err != nil {
+			t.Errorf("parse %q: %v", tt.pointer, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, tt.tokens) {
+			t.Errorf("parse %q = %q, want %q", tt.pointer, tokens, tt.tokens)
+		}
+	}
+	if _, err := parsePointer("foo"); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("parse of a relative pointer = %v, want %v", err, ErrInvalidPatch)
+	}
+}
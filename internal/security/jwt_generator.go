@@ -6,6 +6,7 @@
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 )
 
 const minSecretKeySize = 32
@@ -24,8 +25,8 @@ func NewJWTGenerator(secretKey string) (TokenGenerator, error) {
 }
 
 // CreateToken creates a new token for a specific username and duration
-func (g *JWTGenerator) Generate(userID uint, username string, duration time.Duration) (string, *TokenPayload, error) {
-	payload, err := NewTokenPayload(userID, username, duration)
+func (g *JWTGenerator) Generate(tokenType TokenType, userID uint, username string, sessionID uuid.UUID, duration time.Duration) (string, *TokenPayload, error) {
+	payload, err := NewTokenPayload(tokenType, userID, username, sessionID, duration)
 	if err != nil {
 		return "", payload, err
 	}
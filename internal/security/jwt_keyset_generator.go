@@ -0,0 +1,66 @@
+package security
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// JWTKeySetGenerator is a JSON Web Token generator signing with the asymmetric keys of a
+// KeySet, so that tokens can be verified with the published public keys alone
+type JWTKeySetGenerator struct {
+	keys *KeySet
+}
+
+// NewJWTKeySetGenerator creates a new JWTKeySetGenerator
+func NewJWTKeySetGenerator(keys *KeySet) (TokenGenerator, error) {
+	if keys == nil || keys.Active() == nil {
+		return nil, errors.New("key set has no active key")
+	}
+	return &JWTKeySetGenerator{keys}, nil
+}
+
+// Generate creates a new token signed with the active key, its kid header names the key
+func (g *JWTKeySetGenerator) Generate(tokenType TokenType, userID uint, username string, sessionID uuid.UUID, duration time.Duration) (string, *TokenPayload, error) {
+	payload, err := NewTokenPayload(tokenType, userID, username, sessionID, duration)
+	if err != nil {
+		return "", payload, err
+	}
+
+	key := g.keys.Active()
+	jwtToken := jwt.NewWithClaims(key.Method, payload)
+	jwtToken.Header["kid"] = key.ID
+	token, err := jwtToken.SignedString(key.PrivateKey)
+	return token, payload, err
+}
+
+// Verify checks the token against the key named by its kid header
+func (g *JWTKeySetGenerator) Verify(token string) (*TokenPayload, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		key, ok := g.keys.Verifying(id, time.Now())
+		if !ok || token.Method.Alg() != key.Method.Alg() {
+			return nil, ErrInvalidToken
+		}
+		return key.PrivateKey.Public(), nil
+	}
+
+	jwtToken, err := jwt.ParseWithClaims(token, &TokenPayload{}, keyFunc)
+	if err != nil {
+		var verr *jwt.ValidationError
+		ok := errors.As(err, &verr)
+		if ok && errors.Is(verr.Inner, ErrExpiredToken) {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+
+	payload, ok := jwtToken.Claims.(*TokenPayload)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,199 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const minRSAKeySize = 2048
+
+// SigningKey is a private key of a KeySet
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	// RetiredAt is when the key stopped signing, it is zero for the active key
+	RetiredAt time.Time
+}
+
+// KeySet holds the active signing key together with the retired keys whose tokens may still
+// be in use. A retired key verifies tokens until its grace period is over.
+type KeySet struct {
+	active      *SigningKey
+	keys        map[string]*SigningKey
+	gracePeriod time.Duration
+}
+
+// keySetFile is the JSON layout of a key set file, private_key paths are relative to the file
+//
+//	{
+//	  "active": "2024-07",
+//	  "keys": [
+//	    {"kid": "2024-07", "private_key": "2024-07.pem"},
+//	    {"kid": "2024-01", "private_key": "2024-01.pem", "retired_at": "2024-07-01T00:00:00Z"}
+//	  ]
+//	}
+type keySetFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		ID         string    `json:"kid"`
+		PrivateKey string    `json:"private_key"`
+		RetiredAt  time.Time `json:"retired_at"`
+	} `json:"keys"`
+}
+
+// LoadKeySet reads a key set file. The keys are PEM encoded Ed25519 or RSA private keys, they
+// sign with EdDSA and RS256 respectively.
+func LoadKeySet(path string, gracePeriod time.Duration) (*KeySet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key set: %w", err)
+	}
+	var file keySetFile
+	if err = json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	set := &KeySet{keys: map[string]*SigningKey{}, gracePeriod: gracePeriod}
+	for _, entry := range file.Keys {
+		if entry.ID == "" {
+			return nil, fmt.Errorf("invalid key set: key without kid")
+		}
+		if _, ok := set.keys[entry.ID]; ok {
+			return nil, fmt.Errorf("invalid key set: duplicated kid %q", entry.ID)
+		}
+		keyPath := entry.PrivateKey
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(filepath.Dir(path), keyPath)
+		}
+		key, err := loadSigningKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", entry.ID, err)
+		}
+		key.ID = entry.ID
+		if entry.ID == file.Active {
+			set.active = key
+		} else {
+			if entry.RetiredAt.IsZero() {
+				return nil, fmt.Errorf("invalid key set: key %q is neither active nor retired", entry.ID)
+			}
+			key.RetiredAt = entry.RetiredAt
+		}
+		set.keys[entry.ID] = key
+	}
+	if set.active == nil {
+		return nil, fmt.Errorf("invalid key set: active key %q not found", file.Active)
+	}
+	return set, nil
+}
+
+func loadSigningKey(path string) (*SigningKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read private key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	switch key := parsed.(type) {
+	case ed25519.PrivateKey:
+		return &SigningKey{Method: jwt.SigningMethodEdDSA, PrivateKey: key}, nil
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minRSAKeySize {
+			return nil, fmt.Errorf("invalid key size: rsa keys must be at least %d bits", minRSAKeySize)
+		}
+		return &SigningKey{Method: jwt.SigningMethodRS256, PrivateKey: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+}
+
+// Active returns the key new tokens are signed with
+func (s *KeySet) Active() *SigningKey {
+	return s.active
+}
+
+// Verifying returns the key with the given id if it may verify tokens at t
+func (s *KeySet) Verifying(id string, t time.Time) (*SigningKey, bool) {
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, false
+	}
+	if !key.RetiredAt.IsZero() && t.After(key.RetiredAt.Add(s.gracePeriod)) {
+		return nil, false
+	}
+	return key, true
+}
+
+// JSONWebKey is the public part of a signing key as described by RFC 7517
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JSONWebKeySet is the document served at /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys that verify tokens at t, the active key first
+func (s *KeySet) JWKS(t time.Time) JSONWebKeySet {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{s.active.jwk()}}
+	for _, id := range ids {
+		if key, ok := s.Verifying(id, t); ok && key != s.active {
+			set.Keys = append(set.Keys, key.jwk())
+		}
+	}
+	return set
+}
+
+func (k *SigningKey) jwk() JSONWebKey {
+	jwk := JSONWebKey{KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+	switch public := k.PrivateKey.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
@@ -0,0 +1,44 @@
+package security
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MultiFormatGenerator issues tokens with one generator and verifies them with several, it
+// keeps tokens of a previous format working while clients move to a new one
+type MultiFormatGenerator struct {
+	primary TokenGenerator
+	legacy  []TokenGenerator
+}
+
+// NewMultiFormatGenerator creates a new MultiFormatGenerator, tokens are generated by primary
+// and verified by primary or any of legacy
+func NewMultiFormatGenerator(primary TokenGenerator, legacy ...TokenGenerator) TokenGenerator {
+	if len(legacy) == 0 {
+		return primary
+	}
+	return &MultiFormatGenerator{primary: primary, legacy: legacy}
+}
+
+func (g *MultiFormatGenerator) Generate(tokenType TokenType, userID uint, username string, sessionID uuid.UUID, duration time.Duration) (string, *TokenPayload, error) {
+	return g.primary.Generate(tokenType, userID, username, sessionID, duration)
+}
+
+// Verify accepts a token any of the generators accepts, an expired token is reported as such
+// rather than as invalid
+func (g *MultiFormatGenerator) Verify(token string) (*TokenPayload, error) {
+	result := ErrInvalidToken
+	for _, generator := range append([]TokenGenerator{g.primary}, g.legacy...) {
+		payload, err := generator.Verify(token)
+		if err == nil {
+			return payload, nil
+		}
+		if errors.Is(err, ErrExpiredToken) {
+			result = ErrExpiredToken
+		}
+	}
+	return nil, result
+}
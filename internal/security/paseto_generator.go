@@ -0,0 +1,91 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"com.github/asdsec/planny/internal/paseto"
+	"github.com/google/uuid"
+)
+
+// PasetoGenerator is a PASETO v4 token generator. Local tokens are encrypted with a shared
+// key, public tokens are signed with an Ed25519 key and can be verified with its public key.
+type PasetoGenerator struct {
+	localKey   []byte
+	privateKey ed25519.PrivateKey
+}
+
+// NewPasetoLocalGenerator creates a new PasetoGenerator issuing v4.local tokens
+func NewPasetoLocalGenerator(key []byte) (TokenGenerator, error) {
+	if len(key) != paseto.KeySize {
+		return nil, fmt.Errorf("invalid key size: must be %d bytes", paseto.KeySize)
+	}
+	return &PasetoGenerator{localKey: key}, nil
+}
+
+// NewPasetoPublicGenerator creates a new PasetoGenerator issuing v4.public tokens
+func NewPasetoPublicGenerator(privateKey ed25519.PrivateKey) (TokenGenerator, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid key size: must be %d bytes", ed25519.PrivateKeySize)
+	}
+	return &PasetoGenerator{privateKey: privateKey}, nil
+}
+
+// LoadEd25519PrivateKey reads a PEM encoded Ed25519 private key
+func LoadEd25519PrivateKey(path string) (ed25519.PrivateKey, error) {
+	key, err := loadSigningKey(path)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.PrivateKey.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an Ed25519 key")
+	}
+	return privateKey, nil
+}
+
+// Generate creates a new token carrying the same payload as a JSON Web Token would
+func (g *PasetoGenerator) Generate(tokenType TokenType, userID uint, username string, sessionID uuid.UUID, duration time.Duration) (string, *TokenPayload, error) {
+	payload, err := NewTokenPayload(tokenType, userID, username, sessionID, duration)
+	if err != nil {
+		return "", payload, err
+	}
+	message, err := json.Marshal(payload)
+	if err != nil {
+		return "", payload, err
+	}
+
+	var token string
+	if g.localKey != nil {
+		token, err = paseto.Encrypt(g.localKey, message, nil, nil)
+	} else {
+		token, err = paseto.Sign(g.privateKey, message, nil, nil)
+	}
+	return token, payload, err
+}
+
+// Verify checks if the token is valid or not
+func (g *PasetoGenerator) Verify(token string) (*TokenPayload, error) {
+	var message []byte
+	var err error
+	if g.localKey != nil {
+		message, err = paseto.Decrypt(g.localKey, token, nil, nil)
+	} else {
+		message, err = paseto.Verify(g.privateKey.Public().(ed25519.PublicKey), token, nil, nil)
+	}
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	payload := &TokenPayload{}
+	if err = json.Unmarshal(message, payload); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err = payload.Valid(); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
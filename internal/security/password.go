@@ -19,3 +19,12 @@ func HashPassword(password string) (string, error) {
 func CheckPassword(password string, hashedPassword string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
+
+// dummyHash is checked against when there is no user, it is created once at startup
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("planny-dummy-password"), bcrypt.DefaultCost)
+
+// SimulatePasswordCheck spends as long as CheckPassword does, it is used when there is no user
+// to check against so that the response time does not tell whether a user exists
+func SimulatePasswordCheck(password string) {
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
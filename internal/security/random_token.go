@@ -0,0 +1,50 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const randomTokenSize = 32
+
+// GenerateRandomToken returns a URL safe random token suitable for opaque secrets
+func GenerateRandomToken() (string, error) {
+	b := make([]byte, randomTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA-256 hash of a high entropy token, it is used to look tokens up
+// without storing them in plaintext
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const recoveryCodeSize = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCode returns a random code formatted like "abcde-fghij" that is easy to
+// write down
+func GenerateRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeSize*5/8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+	return code[:recoveryCodeSize/2] + "-" + code[recoveryCodeSize/2:], nil
+}
+
+// NormalizeRecoveryCode undoes the formatting of a recovery code typed in by a user
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
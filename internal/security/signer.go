@@ -0,0 +1,70 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signer signs short lived values with HMAC-SHA256 so that they can be handed out in links and
+// verified later without storing them
+type Signer struct {
+	secretKey []byte
+}
+
+// NewSigner creates a new Signer
+func NewSigner(secretKey string) (*Signer, error) {
+	if len(secretKey) < minSecretKeySize {
+		return nil, fmt.Errorf("invalid key size: must be at least %d characters", minSecretKeySize)
+	}
+	return &Signer{secretKey: []byte(secretKey)}, nil
+}
+
+// Sign returns a URL safe token carrying value until expiresAt. The purpose is part of the
+// signature so a token signed for one purpose is rejected for every other.
+func (s *Signer) Sign(purpose, value string, expiresAt time.Time) string {
+	message := base64.RawURLEncoding.EncodeToString([]byte(value)) + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return message + "." + base64.RawURLEncoding.EncodeToString(s.mac(purpose, message))
+}
+
+// Verify checks a token signed for purpose and returns its value
+func (s *Signer) Verify(purpose, token string) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", ErrInvalidToken
+	}
+	message := token[:i]
+	signature, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(signature, s.mac(purpose, message)) {
+		return "", ErrInvalidToken
+	}
+
+	encodedValue, expiry, ok := strings.Cut(message, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", ErrExpiredToken
+	}
+	value, err := base64.RawURLEncoding.DecodeString(encodedValue)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	return string(value), nil
+}
+
+func (s *Signer) mac(purpose, message string) []byte {
+	h := hmac.New(sha256.New, s.secretKey)
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(message))
+	return h.Sum(nil)
+}
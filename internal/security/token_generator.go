@@ -1,10 +1,14 @@
 package security
 
-import "time"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type TokenGenerator interface {
-	// Generate creates a new token for a specific username and duration
-	Generate(userID uint, username string, duration time.Duration) (string, *TokenPayload, error)
+	// Generate creates a new token of a type for a specific username and duration within a session
+	Generate(tokenType TokenType, userID uint, username string, sessionID uuid.UUID, duration time.Duration) (string, *TokenPayload, error)
 
 	// Verify checks if the token is valid or not
 	Verify(token string) (*TokenPayload, error)
@@ -13,17 +13,29 @@
 	ErrExpiredToken = errors.New("token has expired")
 )
 
+// TokenType tells access tokens from the refresh tokens that renew them
+type TokenType string
+
+// Types of the tokens of a session
+const (
+	TokenTypeAccess  TokenType = "access"
+	TokenTypeRefresh TokenType = "refresh"
+)
+
 // TokenPayload contains the payload data of the token
 type TokenPayload struct {
 	ID        uuid.UUID `json:"id"`
+	Type      TokenType `json:"typ"`
+	SessionID uuid.UUID `json:"session_id"`
 	UserID    uint      `json:"user_id"`
 	Username  string    `json:"username"`
 	IssuedAt  time.Time `json:"issued_at"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
-// NewTokenPayload creates a new token payload with a specific username and duration
-func NewTokenPayload(userID uint, username string, duration time.Duration) (*TokenPayload, error) {
+// NewTokenPayload creates a new token payload of a type with a specific username and duration that
+// belongs to the given session
+func NewTokenPayload(tokenType TokenType, userID uint, username string, sessionID uuid.UUID, duration time.Duration) (*TokenPayload, error) {
 	tokenID, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
@@ -31,6 +43,8 @@ func NewTokenPayload(userID uint, username string, duration time.Duration) (*Tok
 
 	payload := &TokenPayload{
 		ID:        tokenID,
+		Type:      tokenType,
+		SessionID: sessionID,
 		UserID:    userID,
 		Username:  username,
 		IssuedAt:  time.Now(),
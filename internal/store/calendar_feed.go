@@ -0,0 +1,79 @@
+package db
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type CalendarFeedEntity struct {
+	ID        uint       `gorm:"primarykey"`
+	TokenHash string     `gorm:"size:64;uniqueIndex"`
+	UserID    uint       `gorm:"uniqueIndex"`
+	User      UserEntity `gorm:"foreignKey:UserID"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type UpsertCalendarFeedArg struct {
+	UserID    uint
+	TokenHash string
+}
+
+// UpsertCalendarFeed stores the feed secret of a user, replacing any previous one
+func (store *SQLStore) UpsertCalendarFeed(ctx context.Context, arg UpsertCalendarFeedArg) (model.CalendarFeed, error) {
+	feedEntity := CalendarFeedEntity{
+		UserID:    arg.UserID,
+		TokenHash: arg.TokenHash,
+	}
+	err := store.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"token_hash", "updated_at"}),
+	}).Create(&feedEntity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
+			return feedEntity.toEmpty(), ErrForeignKeyViolated
+		}
+		return feedEntity.toEmpty(), ErrUnhandled
+	}
+	return feedEntity.toCalendarFeed(), nil
+}
+
+func (store *SQLStore) GetCalendarFeedByTokenHash(ctx context.Context, tokenHash string) (model.CalendarFeed, error) {
+	var feedEntity CalendarFeedEntity
+	err := store.db.Where("token_hash = ?", tokenHash).First(&feedEntity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return feedEntity.toEmpty(), ErrRecordNotFound
+		}
+		return feedEntity.toEmpty(), ErrUnhandled
+	}
+	return feedEntity.toCalendarFeed(), nil
+}
+
+func (store *SQLStore) DeleteCalendarFeedByUserID(ctx context.Context, userID uint) error {
+	result := store.db.Where("user_id = ?", userID).Delete(&CalendarFeedEntity{})
+	if result.Error != nil {
+		return ErrUnhandled
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (e *CalendarFeedEntity) toCalendarFeed() model.CalendarFeed {
+	return model.CalendarFeed{
+		ID:        e.ID,
+		UserID:    e.UserID,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}
+}
+
+func (e *CalendarFeedEntity) toEmpty() model.CalendarFeed {
+	return model.CalendarFeed{}
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// LoginAttemptEntity tracks failed logins per key, a key names either an account or a client
+// address
+type LoginAttemptEntity struct {
+	Key         string `gorm:"primaryKey;size:191"`
+	Failures    uint   `gorm:"not null;default:0"`
+	LockedUntil *time.Time
+	UpdatedAt   time.Time
+}
+
+// LoginLockoutEntity records every lockout for operators, it is never read by the application
+type LoginLockoutEntity struct {
+	ID          uint   `gorm:"primarykey"`
+	Key         string `gorm:"size:191;index"`
+	Failures    uint
+	ClientIp    string
+	UserAgent   string
+	LockedUntil time.Time
+	CreatedAt   time.Time
+}
+
+func (store *SQLStore) GetLoginAttempt(ctx context.Context, key string) (model.LoginAttempt, error) {
+	var attemptEntity LoginAttemptEntity
+	err := store.db.Where("`key` = ?", key).First(&attemptEntity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return attemptEntity.toEmpty(), ErrRecordNotFound
+		}
+		return attemptEntity.toEmpty(), ErrUnhandled
+	}
+	return attemptEntity.toLoginAttempt(), nil
+}
+
+// RecordLoginFailure counts a failed login of key and returns the updated attempt. Failures
+// older than window are forgotten and counting starts over.
+func (store *SQLStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (model.LoginAttempt, error) {
+	now := time.Now()
+	attemptEntity := LoginAttemptEntity{Key: key, Failures: 1, UpdatedAt: now}
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		// failures is assigned before updated_at so that it still sees the previous failure time
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures":   gorm.Expr("IF(updated_at < ?, 1, failures + 1)", now.Add(-window)),
+				"updated_at": now,
+			}),
+		}).Create(&attemptEntity).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("`key` = ?", key).First(&attemptEntity).Error
+	})
+	if err != nil {
+		return attemptEntity.toEmpty(), ErrUnhandled
+	}
+	return attemptEntity.toLoginAttempt(), nil
+}
+
+type LockLoginArg struct {
+	Key         string
+	Failures    uint
+	ClientIp    string
+	UserAgent   string
+	LockedUntil time.Time
+}
+
+// LockLogin refuses logins of a key until arg.LockedUntil and records the lockout
+func (store *SQLStore) LockLogin(ctx context.Context, arg LockLoginArg) error {
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&LoginAttemptEntity{}).
+			Where("`key` = ?", arg.Key).
+			Update("locked_until", arg.LockedUntil).Error
+		if err != nil {
+			return err
+		}
+		lockoutEntity := LoginLockoutEntity{
+			Key:         arg.Key,
+			Failures:    arg.Failures,
+			ClientIp:    arg.ClientIp,
+			UserAgent:   arg.UserAgent,
+			LockedUntil: arg.LockedUntil,
+		}
+		return tx.Create(&lockoutEntity).Error
+	})
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// ResetLoginAttempts forgets the failed logins of a key
+func (store *SQLStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	err := store.db.Where("`key` = ?", key).Delete(&LoginAttemptEntity{}).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+func (a *LoginAttemptEntity) toLoginAttempt() model.LoginAttempt {
+	attempt := model.LoginAttempt{
+		Key:       a.Key,
+		Failures:  a.Failures,
+		UpdatedAt: a.UpdatedAt,
+	}
+	if a.LockedUntil != nil {
+		attempt.LockedUntil = *a.LockedUntil
+	}
+	return attempt
+}
+
+func (a *LoginAttemptEntity) toEmpty() model.LoginAttempt {
+	return model.LoginAttempt{}
+}
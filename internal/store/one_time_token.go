@@ -0,0 +1,119 @@
+package db
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// OneTimeTokenEntity is an expiring single use token emailed to a user, only its hash is stored
+type OneTimeTokenEntity struct {
+	ID        uint               `gorm:"primarykey"`
+	TokenHash string             `gorm:"size:64;uniqueIndex"`
+	Purpose   model.TokenPurpose `gorm:"size:32;index:idx_one_time_token_user_purpose"`
+	UserID    uint               `gorm:"index:idx_one_time_token_user_purpose"`
+	User      UserEntity         `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	UsedAt    *time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type CreateOneTimeTokenArg struct {
+	UserID    uint
+	Purpose   model.TokenPurpose
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+// CreateOneTimeToken stores a new token, the unused tokens of the user issued for the same
+// purpose are discarded so that only the latest email works
+func (store *SQLStore) CreateOneTimeToken(ctx context.Context, arg CreateOneTimeTokenArg) (model.OneTimeToken, error) {
+	tokenEntity := OneTimeTokenEntity{
+		TokenHash: arg.TokenHash,
+		Purpose:   arg.Purpose,
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", arg.UserID, arg.Purpose).
+			Delete(&OneTimeTokenEntity{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Omit("User").Create(&tokenEntity).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
+			return tokenEntity.toEmpty(), ErrForeignKeyViolated
+		}
+		return tokenEntity.toEmpty(), ErrUnhandled
+	}
+	return tokenEntity.toOneTimeToken(), nil
+}
+
+// ConsumeOneTimeToken marks a token as used and returns it. Unknown and expired tokens are
+// reported as ErrRecordNotFound, tokens that have been used before as ErrAlreadyUsed.
+func (store *SQLStore) ConsumeOneTimeToken(ctx context.Context, purpose model.TokenPurpose, tokenHash string) (model.OneTimeToken, error) {
+	var tokenEntity OneTimeTokenEntity
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("token_hash = ? AND purpose = ?", tokenHash, purpose).First(&tokenEntity).Error
+		if err != nil {
+			return err
+		}
+		if time.Now().After(tokenEntity.ExpiresAt) {
+			return gorm.ErrRecordNotFound
+		}
+		now := time.Now()
+		result := tx.Model(&OneTimeTokenEntity{}).
+			Where("id = ? AND used_at IS NULL", tokenEntity.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrAlreadyUsed
+		}
+		tokenEntity.UsedAt = &now
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tokenEntity.toEmpty(), ErrRecordNotFound
+		}
+		if errors.Is(err, ErrAlreadyUsed) {
+			return tokenEntity.toEmpty(), ErrAlreadyUsed
+		}
+		return tokenEntity.toEmpty(), ErrUnhandled
+	}
+	return tokenEntity.toOneTimeToken(), nil
+}
+
+// DeleteOneTimeTokens discards the unused tokens of the user issued for purpose
+func (store *SQLStore) DeleteOneTimeTokens(ctx context.Context, userID uint, purpose model.TokenPurpose) error {
+	err := store.db.Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Delete(&OneTimeTokenEntity{}).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+func (t *OneTimeTokenEntity) toOneTimeToken() model.OneTimeToken {
+	token := model.OneTimeToken{
+		ID:        t.ID,
+		UserID:    t.UserID,
+		Purpose:   t.Purpose,
+		ExpiresAt: t.ExpiresAt,
+		CreatedAt: t.CreatedAt,
+	}
+	if t.UsedAt != nil {
+		token.UsedAt = *t.UsedAt
+	}
+	return token
+}
+
+func (t *OneTimeTokenEntity) toEmpty() model.OneTimeToken {
+	return model.OneTimeToken{}
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// lastUsedPrecision limits how often the last use of a personal access token is written
+const lastUsedPrecision = time.Minute
+
+// PersonalAccessTokenEntity is a long lived token a user creates for scripts, only its hash is
+// stored
+type PersonalAccessTokenEntity struct {
+	ID        uint       `gorm:"primarykey"`
+	UserID    uint       `gorm:"index"`
+	User      UserEntity `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Name      string     `gorm:"size:64"`
+	TokenHash string     `gorm:"size:64;uniqueIndex"`
+	// Scopes are separated by spaces
+	Scopes     string
+	LastUsedAt *time.Time
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+type CreatePersonalAccessTokenArg struct {
+	UserID    uint
+	Name      string
+	TokenHash string
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+func (store *SQLStore) CreatePersonalAccessToken(ctx context.Context, arg CreatePersonalAccessTokenArg) (model.PersonalAccessToken, error) {
+	tokenEntity := PersonalAccessTokenEntity{
+		UserID:    arg.UserID,
+		Name:      arg.Name,
+		TokenHash: arg.TokenHash,
+		Scopes:    strings.Join(arg.Scopes, " "),
+		ExpiresAt: arg.ExpiresAt,
+	}
+	err := store.db.Omit("User").Create(&tokenEntity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
+			return tokenEntity.toEmpty(), ErrForeignKeyViolated
+		}
+		return tokenEntity.toEmpty(), ErrUnhandled
+	}
+	return tokenEntity.toPersonalAccessToken(), nil
+}
+
+// GetPersonalAccessTokenByHash returns a token together with the username of its owner
+func (store *SQLStore) GetPersonalAccessTokenByHash(ctx context.Context, tokenHash string) (model.PersonalAccessToken, error) {
+	var tokenEntity PersonalAccessTokenEntity
+	err := store.db.Preload("User").Where("token_hash = ?", tokenHash).First(&tokenEntity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tokenEntity.toEmpty(), ErrRecordNotFound
+		}
+		return tokenEntity.toEmpty(), ErrUnhandled
+	}
+	return tokenEntity.toPersonalAccessToken(), nil
+}
+
+// ListPersonalAccessTokensByUserID lists the tokens of a user, newest first
+func (store *SQLStore) ListPersonalAccessTokensByUserID(ctx context.Context, userID uint) ([]model.PersonalAccessToken, error) {
+	var tokenEntities []PersonalAccessTokenEntity
+	err := store.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokenEntities).Error
+	if err != nil {
+		return nil, ErrUnhandled
+	}
+	tokens := make([]model.PersonalAccessToken, 0, len(tokenEntities))
+	for _, tokenEntity := range tokenEntities {
+		tokens = append(tokens, tokenEntity.toPersonalAccessToken())
+	}
+	return tokens, nil
+}
+
+// TouchPersonalAccessToken records a use of a token, uses within lastUsedPrecision of the
+// previous one are not written
+func (store *SQLStore) TouchPersonalAccessToken(ctx context.Context, id uint) error {
+	now := time.Now()
+	err := store.db.Model(&PersonalAccessTokenEntity{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-lastUsedPrecision)).
+		Update("last_used_at", now).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// DeletePersonalAccessToken revokes a token of a user, tokens of other users are reported as
+// ErrRecordNotFound
+func (store *SQLStore) DeletePersonalAccessToken(ctx context.Context, userID, id uint) error {
+	result := store.db.Where("id = ? AND user_id = ?", id, userID).Delete(&PersonalAccessTokenEntity{})
+	if result.Error != nil {
+		return ErrUnhandled
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (t *PersonalAccessTokenEntity) toPersonalAccessToken() model.PersonalAccessToken {
+	token := model.PersonalAccessToken{
+		ID:        t.ID,
+		UserID:    t.UserID,
+		Username:  t.User.Username,
+		Name:      t.Name,
+		Scopes:    strings.Fields(t.Scopes),
+		ExpiresAt: t.ExpiresAt,
+		CreatedAt: t.CreatedAt,
+	}
+	if t.LastUsedAt != nil {
+		token.LastUsedAt = *t.LastUsedAt
+	}
+	return token
+}
+
+func (t *PersonalAccessTokenEntity) toEmpty() model.PersonalAccessToken {
+	return model.PersonalAccessToken{}
+}
@@ -1,10 +1,13 @@
 package db
 
 import (
+	"com.github/asdsec/planny/internal/calendar"
 	"com.github/asdsec/planny/internal/model"
 	"context"
 	"errors"
+	"fmt"
 	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
@@ -17,120 +20,387 @@
 )
 
 type PlanEntity struct {
-	ID          uint `gorm:"primarykey"`
-	Title       string
-	Description string
-	StartDate   time.Time
-	EndDate     time.Time
-	Status      Status
-	UserID      uint
-	User        UserEntity `gorm:"foreignKey:UserID"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID             uint `gorm:"primarykey"`
+	Title          string
+	Description    string
+	StartDate      time.Time
+	EndDate        time.Time
+	Status         Status
+	RecurrenceRule string
+	ExDates        string `gorm:"type:text"`
+	ParentID       uint   `gorm:"index"`
+	RecurrenceID   *time.Time
+	ExternalUID    string `gorm:"index"`
+	AutoComplete   bool
+	Version        uint        `gorm:"not null;default:1"`
+	Tags           []TagEntity `gorm:"many2many:plan_tags;joinForeignKey:PlanID;joinReferences:TagID;constraint:OnDelete:CASCADE"`
+	UserID         uint
+	User           UserEntity `gorm:"foreignKey:UserID"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
 
 type CreatePlanArg struct {
-	Title       string
-	Description string
-	StartDate   time.Time
-	EndDate     time.Time
-	Status      Status
-	UserID      uint
+	Title          string
+	Description    string
+	StartDate      time.Time
+	EndDate        time.Time
+	Status         Status
+	RecurrenceRule string
+	ExDates        []time.Time
+	ParentID       uint
+	RecurrenceID   time.Time
+	ExternalUID    string
+	AutoComplete   bool
+	Tags           []string
+	UserID         uint
 }
 
+// UpdatePlanArg describes a partial update, nil fields are left untouched
 type UpdatePlanArg struct {
-	ID          uint
-	Title       string
-	Description string
-	StartDate   time.Time
-	EndDate     time.Time
-	Status      Status
+	ID             uint
+	Title          *string
+	Description    *string
+	StartDate      *time.Time
+	EndDate        *time.Time
+	Status         *Status
+	RecurrenceRule *string
+	// ExDates replaces the exception dates of the plan unless nil
+	ExDates      []time.Time
+	AutoComplete *bool
+	// Tags replaces the tags of the plan unless nil
+	Tags []string
+	// Version, when set, only applies the update if the stored version still matches
+	Version uint
 }
 
-func (arg *UpdatePlanArg) toEntity() *PlanEntity {
-	return &PlanEntity{
-		ID:          arg.ID,
-		Title:       arg.Title,
-		Description: arg.Description,
-		StartDate:   arg.StartDate,
-		EndDate:     arg.EndDate,
-		Status:      arg.Status,
+type DeletePlanArg struct {
+	ID uint
+	// Version, when set, only deletes the plan if the stored version still matches
+	Version uint
+}
+
+// updates returns the columns changed by the update, every update moves the version on
+func (arg *UpdatePlanArg) updates() map[string]interface{} {
+	updates := map[string]interface{}{"version": gorm.Expr("version + 1")}
+	if arg.Title != nil {
+		updates["title"] = *arg.Title
+	}
+	if arg.Description != nil {
+		updates["description"] = *arg.Description
+	}
+	if arg.StartDate != nil {
+		updates["start_date"] = *arg.StartDate
+	}
+	if arg.EndDate != nil {
+		updates["end_date"] = *arg.EndDate
+	}
+	if arg.Status != nil {
+		updates["status"] = *arg.Status
 	}
+	if arg.RecurrenceRule != nil {
+		updates["recurrence_rule"] = *arg.RecurrenceRule
+	}
+	if arg.ExDates != nil {
+		updates["ex_dates"] = formatExDates(arg.ExDates)
+	}
+	if arg.AutoComplete != nil {
+		updates["auto_complete"] = *arg.AutoComplete
+	}
+	return updates
 }
 
 func (store *SQLStore) CreatePlan(ctx context.Context, arg CreatePlanArg) (model.Plan, error) {
 	planEntity := PlanEntity{
-		Title:       arg.Title,
-		Description: arg.Description,
-		StartDate:   arg.StartDate,
-		EndDate:     arg.EndDate,
-		Status:      arg.Status,
-		UserID:      arg.UserID,
-	}
-	err := store.db.Create(&planEntity).Error
+		Title:          arg.Title,
+		Description:    arg.Description,
+		StartDate:      arg.StartDate,
+		EndDate:        arg.EndDate,
+		Status:         arg.Status,
+		RecurrenceRule: arg.RecurrenceRule,
+		ExDates:        formatExDates(arg.ExDates),
+		ParentID:       arg.ParentID,
+		ExternalUID:    arg.ExternalUID,
+		AutoComplete:   arg.AutoComplete,
+		Version:        1,
+		UserID:         arg.UserID,
+	}
+	if !arg.RecurrenceID.IsZero() {
+		planEntity.RecurrenceID = &arg.RecurrenceID
+	}
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Tags").Create(&planEntity).Error; err != nil {
+			return err
+		}
+		if len(arg.Tags) == 0 {
+			return nil
+		}
+		return setPlanTags(tx, &planEntity, arg.Tags)
+	})
 	if err != nil {
 		if errors.Is(err, gorm.ErrForeignKeyViolated) {
-			return planEntity.toEmpty(), errors.New(ErrForeignKeyViolated)
+			return planEntity.toEmpty(), ErrForeignKeyViolated
 		}
-		return planEntity.toEmpty(), errors.New(ErrUnhandled)
+		return planEntity.toEmpty(), ErrUnhandled
 	}
 	return planEntity.toPlan(), nil
 }
 
 func (store *SQLStore) GetPlanByID(ctx context.Context, id uint) (model.Plan, error) {
 	var planEntity PlanEntity
-	err := store.db.First(&planEntity, id).Error
+	err := store.db.Preload("Tags").First(&planEntity, id).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return planEntity.toEmpty(), errors.New(ErrRecordNotFound)
+			return planEntity.toEmpty(), ErrRecordNotFound
 		}
-		return planEntity.toEmpty(), errors.New(ErrUnhandled)
+		return planEntity.toEmpty(), ErrUnhandled
 	}
-	return planEntity.toPlan(), nil
+	plans := []model.Plan{planEntity.toPlan()}
+	if err = store.fillItemCounts(plans); err != nil {
+		return planEntity.toEmpty(), err
+	}
+	return plans[0], nil
 }
 
 func (store *SQLStore) ListPlansByUserID(ctx context.Context, userID uint) ([]model.Plan, error) {
 	var planEntities []PlanEntity
-	err := store.db.Where("user_id = ?", userID).Find(&planEntities).Error
+	err := store.db.Preload("Tags").Where("user_id = ?", userID).Find(&planEntities).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New(ErrRecordNotFound)
+			return nil, ErrRecordNotFound
+		}
+		return nil, ErrUnhandled
+	}
+	return store.toPlans(planEntities)
+}
+
+// PlanSortField is a column plans can be ordered by
+type PlanSortField string
+
+const (
+	SortByStartDate PlanSortField = "start_date"
+	SortByEndDate   PlanSortField = "end_date"
+	SortByCreatedAt PlanSortField = "created_at"
+	SortByUpdatedAt PlanSortField = "updated_at"
+	SortByTitle     PlanSortField = "title"
+)
+
+// PlanCursor is the position after which a page of plans starts, Title is used when sorting
+// by title and Time otherwise
+type PlanCursor struct {
+	Time  time.Time
+	Title string
+	ID    uint
+}
+
+// ListPlansArg filters, orders and pages the plans of a user. Zero values disable a filter.
+type ListPlansArg struct {
+	UserID uint
+	// From and To keep plans intersecting the window, recurring series are kept when they
+	// start before To since their end is only known after expansion
+	From         time.Time
+	To           time.Time
+	Statuses     []Status
+	Search       string
+	Tags         []string
+	MatchAllTags bool
+	SortBy       PlanSortField
+	Descending   bool
+	After        *PlanCursor
+	// Limit counts plans, a recurring series is one plan however many occurrences it has
+	Limit int
+}
+
+// ListPlans returns the plans of a user matching arg, ordered by arg.SortBy and then by id
+func (store *SQLStore) ListPlans(ctx context.Context, arg ListPlansArg) ([]model.Plan, error) {
+	query := store.db.Preload("Tags").Where("user_id = ?", arg.UserID)
+	if !arg.From.IsZero() {
+		query = query.Where("(end_date > ? OR recurrence_rule <> '')", arg.From)
+	}
+	if !arg.To.IsZero() {
+		query = query.Where("start_date < ?", arg.To)
+	}
+	if len(arg.Statuses) > 0 {
+		query = query.Where("status IN ?", arg.Statuses)
+	}
+	if arg.Search != "" {
+		pattern := "%" + escapeLike(arg.Search) + "%"
+		query = query.Where("(title LIKE ? OR description LIKE ?)", pattern, pattern)
+	}
+	if len(arg.Tags) > 0 {
+		query = query.Where("id IN (?)", taggedPlanIDs(store.db, arg.UserID, arg.Tags, arg.MatchAllTags))
+	}
+
+	column := string(SortByStartDate)
+	switch arg.SortBy {
+	case SortByEndDate, SortByCreatedAt, SortByUpdatedAt, SortByTitle:
+		column = string(arg.SortBy)
+	}
+	direction, comparison := "ASC", ">"
+	if arg.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if arg.After != nil {
+		var value interface{} = arg.After.Time
+		if column == string(SortByTitle) {
+			value = arg.After.Title
 		}
-		return nil, errors.New(ErrUnhandled)
+		query = query.Where(
+			fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, comparison),
+			value, value, arg.After.ID,
+		)
+	}
+	query = query.Order(column + " " + direction).Order("id " + direction)
+	if arg.Limit > 0 {
+		query = query.Limit(arg.Limit)
+	}
+
+	var planEntities []PlanEntity
+	if err := query.Find(&planEntities).Error; err != nil {
+		return nil, ErrUnhandled
 	}
+	return store.toPlans(planEntities)
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func (store *SQLStore) toPlans(planEntities []PlanEntity) ([]model.Plan, error) {
 	var plans []model.Plan
 	for _, planEntity := range planEntities {
 		plans = append(plans, planEntity.toPlan())
 	}
+	if err := store.fillItemCounts(plans); err != nil {
+		return nil, err
+	}
 	return plans, nil
 }
 
+// UpdatePlanByID applies arg to the plan, the version check and the version bump happen in
+// the same UPDATE statement so that concurrent writers cannot overwrite each other
 func (store *SQLStore) UpdatePlanByID(ctx context.Context, arg UpdatePlanArg) (model.Plan, error) {
-	planEntity := arg.toEntity()
-	err := store.db.Model(planEntity).Updates(planEntity).Error
+	planEntity := &PlanEntity{ID: arg.ID}
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(planEntity)
+		if arg.Version != 0 {
+			query = query.Where("version = ?", arg.Version)
+		}
+		result := query.Updates(arg.updates())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return planWriteMissed(tx, arg.ID)
+		}
+		if arg.Tags == nil {
+			return nil
+		}
+		if err := tx.First(planEntity, arg.ID).Error; err != nil {
+			return err
+		}
+		return setPlanTags(tx, planEntity, arg.Tags)
+	})
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return planEntity.toPlan(), errors.New(ErrRecordNotFound)
+			return planEntity.toPlan(), ErrRecordNotFound
+		}
+		if errors.Is(err, ErrVersionConflict) {
+			return planEntity.toPlan(), ErrVersionConflict
 		}
-		return planEntity.toPlan(), errors.New(ErrUnhandled)
+		return planEntity.toPlan(), ErrUnhandled
 	}
-	err = store.db.Model(planEntity).Find(planEntity).Error
+	err = store.db.Model(planEntity).Preload("Tags").Find(planEntity).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return planEntity.toPlan(), errors.New(ErrRecordNotFound)
+			return planEntity.toPlan(), ErrRecordNotFound
 		}
-		return planEntity.toPlan(), errors.New(ErrUnhandled)
+		return planEntity.toPlan(), ErrUnhandled
 	}
-	return planEntity.toPlan(), nil
+	plans := []model.Plan{planEntity.toPlan()}
+	if err = store.fillItemCounts(plans); err != nil {
+		return planEntity.toPlan(), err
+	}
+	return plans[0], nil
 }
 
-func (store *SQLStore) DeletePlanByID(ctx context.Context, id uint) error {
-	err := store.db.Delete(&PlanEntity{}, id).Error
+// DeletePlanByID deletes the plan together with the detached occurrences of its series
+func (store *SQLStore) DeletePlanByID(ctx context.Context, arg DeletePlanArg) error {
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("id = ?", arg.ID)
+		if arg.Version != 0 {
+			query = query.Where("version = ?", arg.Version)
+		}
+		result := query.Delete(&PlanEntity{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return planWriteMissed(tx, arg.ID)
+		}
+		return tx.Where("parent_id = ?", arg.ID).Delete(&PlanEntity{}).Error
+	})
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New(ErrRecordNotFound)
+			return ErrRecordNotFound
 		}
-		return errors.New(ErrUnhandled)
+		if errors.Is(err, ErrVersionConflict) {
+			return ErrVersionConflict
+		}
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// touchPlans moves the version of the plans matching the condition on. Items and tag names are
+// part of a plan as clients see it, so writing them outdates the entity tag of the plan.
+func touchPlans(tx *gorm.DB, query string, args ...interface{}) error {
+	return tx.Model(&PlanEntity{}).Where(query, args...).Updates(map[string]interface{}{
+		"version":    gorm.Expr("version + 1"),
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// planWriteMissed tells why a guarded write affected no rows, either the plan is gone or its
+// version moved on
+func planWriteMissed(tx *gorm.DB, id uint) error {
+	var count int64
+	if err := tx.Model(&PlanEntity{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return ErrVersionConflict
+}
+
+// DeletePlanExceptions deletes the detached occurrences of a series starting from since
+func (store *SQLStore) DeletePlanExceptions(ctx context.Context, parentID uint, since time.Time) error {
+	err := store.db.Where("parent_id = ? AND recurrence_id >= ?", parentID, since).Delete(&PlanEntity{}).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// ReparentPlanExceptions moves the detached occurrences starting from since to another series
+func (store *SQLStore) ReparentPlanExceptions(ctx context.Context, fromID, toID uint, since time.Time) error {
+	err := store.db.Model(&PlanEntity{}).
+		Where("parent_id = ? AND recurrence_id >= ?", fromID, since).
+		Update("parent_id", toID).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// ShiftPlanExceptions moves the detached occurrences of a series by d along with its start
+func (store *SQLStore) ShiftPlanExceptions(ctx context.Context, parentID uint, d time.Duration) error {
+	err := store.db.Model(&PlanEntity{}).
+		Where("parent_id = ?", parentID).
+		Update("recurrence_id", gorm.Expr("DATE_ADD(recurrence_id, INTERVAL ? MICROSECOND)", d.Microseconds())).Error
+	if err != nil {
+		return ErrUnhandled
 	}
 	return nil
 }
@@ -140,17 +410,52 @@ func (e *PlanEntity) toEmpty() model.Plan {
 }
 
 func (e *PlanEntity) toPlan() model.Plan {
-	return model.Plan{
-		ID:          e.ID,
-		Title:       e.Title,
-		Description: e.Description,
-		StartDate:   e.StartDate,
-		EndDate:     e.EndDate,
-		Status:      e.Status.toModelStatus(),
-		UserID:      e.UserID,
-		CreatedAt:   e.CreatedAt,
-		UpdatedAt:   e.UpdatedAt,
+	plan := model.Plan{
+		ID:             e.ID,
+		Title:          e.Title,
+		Description:    e.Description,
+		StartDate:      e.StartDate,
+		EndDate:        e.EndDate,
+		Status:         e.Status.toModelStatus(),
+		RecurrenceRule: e.RecurrenceRule,
+		ExDates:        parseExDates(e.ExDates),
+		ParentID:       e.ParentID,
+		ExternalUID:    e.ExternalUID,
+		AutoComplete:   e.AutoComplete,
+		Version:        e.Version,
+		UserID:         e.UserID,
+		CreatedAt:      e.CreatedAt,
+		UpdatedAt:      e.UpdatedAt,
+	}
+	for _, tagEntity := range e.Tags {
+		plan.Tags = append(plan.Tags, tagEntity.toTag())
+	}
+	if e.RecurrenceID != nil {
+		plan.RecurrenceID = *e.RecurrenceID
+	}
+	return plan
+}
+
+func formatExDates(exDates []time.Time) string {
+	values := make([]string, 0, len(exDates))
+	for _, exDate := range exDates {
+		values = append(values, calendar.FormatDateTime(exDate))
+	}
+	return strings.Join(values, ",")
+}
+
+func parseExDates(value string) []time.Time {
+	if value == "" {
+		return nil
+	}
+	var exDates []time.Time
+	for _, item := range strings.Split(value, ",") {
+		exDate, err := calendar.ParseDateTime(item)
+		if err == nil {
+			exDates = append(exDates, exDate)
+		}
 	}
+	return exDates
 }
 
 func (s *Status) toModelStatus() model.Status {
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+type PlanItemEntity struct {
+	ID        uint `gorm:"primarykey"`
+	Title     string
+	Position  int
+	IsDone    bool
+	PlanID    uint       `gorm:"index"`
+	Plan      PlanEntity `gorm:"foreignKey:PlanID;constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type CreatePlanItemArg struct {
+	PlanID   uint
+	Title    string
+	Position int
+}
+
+type UpdatePlanItemArg struct {
+	ID     uint
+	Title  string
+	IsDone *bool
+}
+
+func (store *SQLStore) CreatePlanItem(ctx context.Context, arg CreatePlanItemArg) (model.PlanItem, error) {
+	itemEntity := PlanItemEntity{
+		PlanID:   arg.PlanID,
+		Title:    arg.Title,
+		Position: arg.Position,
+	}
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&itemEntity).Error; err != nil {
+			return err
+		}
+		return touchPlans(tx, "id = ?", arg.PlanID)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
+			return itemEntity.toEmpty(), ErrForeignKeyViolated
+		}
+		return itemEntity.toEmpty(), ErrUnhandled
+	}
+	return itemEntity.toPlanItem(), nil
+}
+
+func (store *SQLStore) GetPlanItemByID(ctx context.Context, id uint) (model.PlanItem, error) {
+	var itemEntity PlanItemEntity
+	err := store.db.First(&itemEntity, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return itemEntity.toEmpty(), ErrRecordNotFound
+		}
+		return itemEntity.toEmpty(), ErrUnhandled
+	}
+	return itemEntity.toPlanItem(), nil
+}
+
+// ListPlanItemsByPlanID returns the items of a plan in their checklist order
+func (store *SQLStore) ListPlanItemsByPlanID(ctx context.Context, planID uint) ([]model.PlanItem, error) {
+	var itemEntities []PlanItemEntity
+	err := store.db.Where("plan_id = ?", planID).Order("position, id").Find(&itemEntities).Error
+	if err != nil {
+		return nil, ErrUnhandled
+	}
+	items := make([]model.PlanItem, 0, len(itemEntities))
+	for _, itemEntity := range itemEntities {
+		items = append(items, itemEntity.toPlanItem())
+	}
+	return items, nil
+}
+
+func (store *SQLStore) UpdatePlanItemByID(ctx context.Context, arg UpdatePlanItemArg) (model.PlanItem, error) {
+	itemEntity := PlanItemEntity{ID: arg.ID}
+	updates := map[string]interface{}{}
+	if arg.Title != "" {
+		updates["title"] = arg.Title
+	}
+	if arg.IsDone != nil {
+		updates["is_done"] = *arg.IsDone
+	}
+	if len(updates) > 0 {
+		err := store.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&itemEntity).Updates(updates).Error; err != nil {
+				return err
+			}
+			return touchPlans(tx, "id = (SELECT plan_id FROM plan_item_entities WHERE id = ?)", arg.ID)
+		})
+		if err != nil {
+			return itemEntity.toEmpty(), ErrUnhandled
+		}
+	}
+	err := store.db.First(&itemEntity, arg.ID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return itemEntity.toEmpty(), ErrRecordNotFound
+		}
+		return itemEntity.toEmpty(), ErrUnhandled
+	}
+	return itemEntity.toPlanItem(), nil
+}
+
+// ReorderPlanItems stores the position of every item of a plan following the order of ids
+func (store *SQLStore) ReorderPlanItems(ctx context.Context, planID uint, ids []uint) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		for position, id := range ids {
+			err := tx.Model(&PlanItemEntity{}).
+				Where("id = ? AND plan_id = ?", id, planID).
+				Update("position", position).Error
+			if err != nil {
+				return ErrUnhandled
+			}
+		}
+		if err := touchPlans(tx, "id = ?", planID); err != nil {
+			return ErrUnhandled
+		}
+		return nil
+	})
+}
+
+func (store *SQLStore) DeletePlanItemByID(ctx context.Context, id uint) error {
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		if err := touchPlans(tx, "id = (SELECT plan_id FROM plan_item_entities WHERE id = ?)", id); err != nil {
+			return err
+		}
+		return tx.Delete(&PlanItemEntity{}, id).Error
+	})
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+type planItemCount struct {
+	PlanID uint
+	Total  int
+	Done   int
+}
+
+// fillItemCounts sets the checklist counters of the given plans
+func (store *SQLStore) fillItemCounts(plans []model.Plan) error {
+	if len(plans) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(plans))
+	for _, plan := range plans {
+		ids = append(ids, plan.ID)
+	}
+
+	var counts []planItemCount
+	err := store.db.Model(&PlanItemEntity{}).
+		Select("plan_id, COUNT(*) AS total, SUM(CASE WHEN is_done THEN 1 ELSE 0 END) AS done").
+		Where("plan_id IN ?", ids).
+		Group("plan_id").
+		Scan(&counts).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+
+	byPlan := make(map[uint]planItemCount, len(counts))
+	for _, count := range counts {
+		byPlan[count.PlanID] = count
+	}
+	for i := range plans {
+		plans[i].ItemCount = byPlan[plans[i].ID].Total
+		plans[i].DoneItemCount = byPlan[plans[i].ID].Done
+	}
+	return nil
+}
+
+func (e *PlanItemEntity) toPlanItem() model.PlanItem {
+	return model.PlanItem{
+		ID:        e.ID,
+		PlanID:    e.PlanID,
+		Title:     e.Title,
+		Position:  e.Position,
+		IsDone:    e.IsDone,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}
+}
+
+func (e *PlanItemEntity) toEmpty() model.PlanItem {
+	return model.PlanItem{}
+}
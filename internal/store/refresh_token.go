@@ -0,0 +1,89 @@
+package db
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RefreshTokenEntity is one refresh token of a session, only its hash is stored. Used tokens are
+// kept so that presenting one again can be detected.
+type RefreshTokenEntity struct {
+	ID        uint          `gorm:"primarykey"`
+	TokenHash string        `gorm:"size:64;uniqueIndex"`
+	SessionID uuid.UUID     `gorm:"index"`
+	Session   SessionEntity `gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE"`
+	UsedAt    *time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type RotateRefreshTokenArg struct {
+	SessionID uuid.UUID
+	// UsedHash is the hash of the token being exchanged
+	UsedHash  string
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+func (store *SQLStore) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (model.RefreshToken, error) {
+	var tokenEntity RefreshTokenEntity
+	err := store.db.Where("token_hash = ?", tokenHash).First(&tokenEntity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tokenEntity.toEmpty(), ErrRecordNotFound
+		}
+		return tokenEntity.toEmpty(), ErrUnhandled
+	}
+	return tokenEntity.toRefreshToken(), nil
+}
+
+// RotateRefreshToken marks the used token and stores its successor. Marking only succeeds for a
+// token that has not been used yet, so of two concurrent renewals with the same token one gets
+// ErrAlreadyUsed.
+func (store *SQLStore) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenArg) (model.RefreshToken, error) {
+	tokenEntity := RefreshTokenEntity{
+		TokenHash: arg.TokenHash,
+		SessionID: arg.SessionID,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RefreshTokenEntity{}).
+			Where("token_hash = ? AND session_id = ? AND used_at IS NULL", arg.UsedHash, arg.SessionID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrAlreadyUsed
+		}
+		return tx.Omit("Session").Create(&tokenEntity).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrAlreadyUsed) {
+			return tokenEntity.toEmpty(), ErrAlreadyUsed
+		}
+		return tokenEntity.toEmpty(), ErrUnhandled
+	}
+	return tokenEntity.toRefreshToken(), nil
+}
+
+func (t *RefreshTokenEntity) toRefreshToken() model.RefreshToken {
+	token := model.RefreshToken{
+		ID:        t.ID,
+		SessionID: t.SessionID,
+		ExpiresAt: t.ExpiresAt,
+		CreatedAt: t.CreatedAt,
+	}
+	if t.UsedAt != nil {
+		token.UsedAt = *t.UsedAt
+	}
+	return token
+}
+
+func (t *RefreshTokenEntity) toEmpty() model.RefreshToken {
+	return model.RefreshToken{}
+}
@@ -10,71 +10,144 @@
 )
 
 type SessionEntity struct {
-	ID           uuid.UUID `gorm:"primarykey"`
-	Username     string
-	RefreshToken string
-	UserAgent    string
-	ClientIp     string
-	ExpiresAt    time.Time
-	UserID       uint
-	User         UserEntity `gorm:"foreignKey:UserID"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID         uuid.UUID `gorm:"primarykey"`
+	Username   string
+	UserAgent  string
+	ClientIp   string
+	ExpiresAt  time.Time
+	IsBlocked  bool       `gorm:"not null;default:false"`
+	LastUsedAt time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP(3)"`
+	UserID     uint       `gorm:"index"`
+	User       UserEntity `gorm:"foreignKey:UserID"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 type CreateSessionArg struct {
-	ID           uuid.UUID
-	UserID       uint
-	Username     string
-	RefreshToken string
-	UserAgent    string
-	ClientIp     string
-	ExpiresAt    time.Time
+	ID       uuid.UUID
+	UserID   uint
+	Username string
+	// RefreshTokenHash is the hash of the first refresh token of the session
+	RefreshTokenHash string
+	UserAgent        string
+	ClientIp         string
+	ExpiresAt        time.Time
 }
 
 func (store *SQLStore) CreateSession(ctx context.Context, arg CreateSessionArg) (model.Session, error) {
 	sessionEntity := SessionEntity{
-		ID:           arg.ID,
-		UserID:       arg.UserID,
-		Username:     arg.Username,
-		RefreshToken: arg.RefreshToken,
-		UserAgent:    arg.UserAgent,
-		ClientIp:     arg.ClientIp,
-		ExpiresAt:    arg.ExpiresAt,
+		ID:         arg.ID,
+		UserID:     arg.UserID,
+		Username:   arg.Username,
+		UserAgent:  arg.UserAgent,
+		ClientIp:   arg.ClientIp,
+		ExpiresAt:  arg.ExpiresAt,
+		LastUsedAt: time.Now(),
 	}
-	err := store.db.Create(&sessionEntity).Error
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&sessionEntity).Error; err != nil {
+			return err
+		}
+		return tx.Omit("Session").Create(&RefreshTokenEntity{
+			TokenHash: arg.RefreshTokenHash,
+			SessionID: sessionEntity.ID,
+			ExpiresAt: arg.ExpiresAt,
+		}).Error
+	})
 	if err != nil {
 		if errors.Is(err, gorm.ErrForeignKeyViolated) {
-			return sessionEntity.toEmpty(), errors.New(ErrForeignKeyViolated)
+			return sessionEntity.toEmpty(), ErrForeignKeyViolated
 		}
-		return sessionEntity.toEmpty(), errors.New(ErrUnhandled)
+		return sessionEntity.toEmpty(), ErrUnhandled
 	}
 	return sessionEntity.toSession(), nil
 }
 
-func (store *SQLStore) GetSessionByRefreshToken(ctx context.Context, refreshToken string) (model.Session, error) {
+func (store *SQLStore) GetSessionByID(ctx context.Context, id uuid.UUID) (model.Session, error) {
 	var sessionEntity SessionEntity
-	err := store.db.Where("refresh_token = ?", refreshToken).First(&sessionEntity).Error
+	err := store.db.First(&sessionEntity, "id = ?", id).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return sessionEntity.toEmpty(), errors.New(ErrRecordNotFound)
+			return sessionEntity.toEmpty(), ErrRecordNotFound
 		}
-		return sessionEntity.toEmpty(), errors.New(ErrUnhandled)
+		return sessionEntity.toEmpty(), ErrUnhandled
 	}
 	return sessionEntity.toSession(), nil
 }
 
+// ListActiveSessionsByUserID lists the sessions of a user that are neither revoked nor expired,
+// most recently used first
+func (store *SQLStore) ListActiveSessionsByUserID(ctx context.Context, userID uint) ([]model.Session, error) {
+	var sessionEntities []SessionEntity
+	err := store.db.
+		Where("user_id = ? AND is_blocked = ? AND expires_at > ?", userID, false, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessionEntities).Error
+	if err != nil {
+		return nil, ErrUnhandled
+	}
+	sessions := make([]model.Session, 0, len(sessionEntities))
+	for _, sessionEntity := range sessionEntities {
+		sessions = append(sessions, sessionEntity.toSession())
+	}
+	return sessions, nil
+}
+
+// TouchSession records that the session has just been used
+func (store *SQLStore) TouchSession(ctx context.Context, id uuid.UUID) error {
+	err := store.db.Model(&SessionEntity{}).Where("id = ?", id).Update("last_used_at", time.Now()).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// BlockSession revokes a session, its refresh token can no longer renew access
+func (store *SQLStore) BlockSession(ctx context.Context, id uuid.UUID) error {
+	result := store.db.Model(&SessionEntity{}).Where("id = ?", id).Update("is_blocked", true)
+	if result.Error != nil {
+		return ErrUnhandled
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// BlockSessionsByUserID revokes every session of a user
+func (store *SQLStore) BlockSessionsByUserID(ctx context.Context, userID uint) error {
+	err := store.db.Model(&SessionEntity{}).
+		Where("user_id = ? AND is_blocked = ?", userID, false).
+		Update("is_blocked", true).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// BlockOtherSessions revokes every session of a user except the one it is using
+func (store *SQLStore) BlockOtherSessions(ctx context.Context, userID uint, currentID uuid.UUID) error {
+	err := store.db.Model(&SessionEntity{}).
+		Where("user_id = ? AND id <> ? AND is_blocked = ?", userID, currentID, false).
+		Update("is_blocked", true).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
 func (s *SessionEntity) toSession() model.Session {
 	return model.Session{
-		ID:           s.ID,
-		UserID:       s.UserID,
-		Username:     s.Username,
-		RefreshToken: s.RefreshToken,
-		UserAgent:    s.UserAgent,
-		ClientIp:     s.ClientIp,
-		ExpiresAt:    s.ExpiresAt,
-		CreatedAt:    s.CreatedAt,
-		UpdatedAt:    s.UpdatedAt,
+		ID:         s.ID,
+		UserID:     s.UserID,
+		Username:   s.Username,
+		UserAgent:  s.UserAgent,
+		ClientIp:   s.ClientIp,
+		ExpiresAt:  s.ExpiresAt,
+		IsBlocked:  s.IsBlocked,
+		LastUsedAt: s.LastUsedAt,
+		CreatedAt:  s.CreatedAt,
+		UpdatedAt:  s.UpdatedAt,
 	}
 }
 
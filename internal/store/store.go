@@ -3,14 +3,19 @@
 import (
 	"com.github/asdsec/planny/internal/model"
 	"context"
+	"errors"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"time"
 )
 
-const (
-	ErrRecordNotFound     = "record not found"
-	ErrForeignKeyViolated = "foreign key constraint violated"
-	ErrDuplicatedKey      = "duplicated key"
-	ErrUnhandled          = "unhandled error"
+var (
+	ErrRecordNotFound     = errors.New("record not found")
+	ErrForeignKeyViolated = errors.New("foreign key constraint violated")
+	ErrDuplicatedKey      = errors.New("duplicated key")
+	ErrVersionConflict    = errors.New("version conflict")
+	ErrAlreadyUsed        = errors.New("already used")
+	ErrUnhandled          = errors.New("unhandled error")
 )
 
 type Store interface {
@@ -18,13 +23,62 @@ type Store interface {
 	GetUserById(ctx context.Context, id uint) (model.User, error)
 	GetUserByUsername(ctx context.Context, username string) (model.User, error)
 	GetUserByEmail(ctx context.Context, email string) (model.User, error)
+	UpdateUser(ctx context.Context, arg UpdateUserArg) (model.User, error)
+	DeleteUser(ctx context.Context, id uint) error
+	MarkUserEmailVerified(ctx context.Context, id uint) error
+	UpdateUserPassword(ctx context.Context, id uint, hashedPassword string) error
+	SetUserTotpSecret(ctx context.Context, id uint, secret string) error
+	EnableUserTotp(ctx context.Context, arg EnableUserTotpArg) error
+	DisableUserTotp(ctx context.Context, id uint) error
+	UseTotpCounter(ctx context.Context, id uint, counter int64) error
+	UseRecoveryCode(ctx context.Context, userID uint, codeHash string) error
+	CreateUserIdentity(ctx context.Context, arg CreateUserIdentityArg) (model.UserIdentity, error)
+	GetUserIdentity(ctx context.Context, provider, subject string) (model.UserIdentity, error)
+	GetLoginAttempt(ctx context.Context, key string) (model.LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (model.LoginAttempt, error)
+	LockLogin(ctx context.Context, arg LockLoginArg) error
+	ResetLoginAttempts(ctx context.Context, key string) error
 	CreateSession(ctx context.Context, arg CreateSessionArg) (model.Session, error)
-	GetSessionByRefreshToken(ctx context.Context, refreshToken string) (model.Session, error)
+	GetSessionByID(ctx context.Context, id uuid.UUID) (model.Session, error)
+	ListActiveSessionsByUserID(ctx context.Context, userID uint) ([]model.Session, error)
+	TouchSession(ctx context.Context, id uuid.UUID) error
+	BlockSession(ctx context.Context, id uuid.UUID) error
+	BlockSessionsByUserID(ctx context.Context, userID uint) error
+	BlockOtherSessions(ctx context.Context, userID uint, currentID uuid.UUID) error
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (model.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenArg) (model.RefreshToken, error)
+	CreatePersonalAccessToken(ctx context.Context, arg CreatePersonalAccessTokenArg) (model.PersonalAccessToken, error)
+	GetPersonalAccessTokenByHash(ctx context.Context, tokenHash string) (model.PersonalAccessToken, error)
+	ListPersonalAccessTokensByUserID(ctx context.Context, userID uint) ([]model.PersonalAccessToken, error)
+	TouchPersonalAccessToken(ctx context.Context, id uint) error
+	DeletePersonalAccessToken(ctx context.Context, userID, id uint) error
+	CreateOneTimeToken(ctx context.Context, arg CreateOneTimeTokenArg) (model.OneTimeToken, error)
+	ConsumeOneTimeToken(ctx context.Context, purpose model.TokenPurpose, tokenHash string) (model.OneTimeToken, error)
+	DeleteOneTimeTokens(ctx context.Context, userID uint, purpose model.TokenPurpose) error
 	CreatePlan(ctx context.Context, arg CreatePlanArg) (model.Plan, error)
 	GetPlanByID(ctx context.Context, id uint) (model.Plan, error)
 	ListPlansByUserID(ctx context.Context, userID uint) ([]model.Plan, error)
+	ListPlans(ctx context.Context, arg ListPlansArg) ([]model.Plan, error)
 	UpdatePlanByID(ctx context.Context, arg UpdatePlanArg) (model.Plan, error)
-	DeletePlanByID(ctx context.Context, id uint) error
+	DeletePlanByID(ctx context.Context, arg DeletePlanArg) error
+	DeletePlanExceptions(ctx context.Context, parentID uint, since time.Time) error
+	ReparentPlanExceptions(ctx context.Context, fromID, toID uint, since time.Time) error
+	ShiftPlanExceptions(ctx context.Context, parentID uint, d time.Duration) error
+	CreatePlanItem(ctx context.Context, arg CreatePlanItemArg) (model.PlanItem, error)
+	GetPlanItemByID(ctx context.Context, id uint) (model.PlanItem, error)
+	ListPlanItemsByPlanID(ctx context.Context, planID uint) ([]model.PlanItem, error)
+	UpdatePlanItemByID(ctx context.Context, arg UpdatePlanItemArg) (model.PlanItem, error)
+	ReorderPlanItems(ctx context.Context, planID uint, ids []uint) error
+	DeletePlanItemByID(ctx context.Context, id uint) error
+	CreateTag(ctx context.Context, arg CreateTagArg) (model.Tag, error)
+	GetTagByID(ctx context.Context, id uint) (model.Tag, error)
+	ListTagsByUserID(ctx context.Context, userID uint) ([]model.Tag, error)
+	UpdateTagByID(ctx context.Context, arg UpdateTagArg) (model.Tag, error)
+	DeleteTagByID(ctx context.Context, id uint) error
+	UpsertCalendarFeed(ctx context.Context, arg UpsertCalendarFeedArg) (model.CalendarFeed, error)
+	GetCalendarFeedByTokenHash(ctx context.Context, tokenHash string) (model.CalendarFeed, error)
+	DeleteCalendarFeedByUserID(ctx context.Context, userID uint) error
+	ExecTx(ctx context.Context, fn func(Store) error) error
 }
 
 // SQLStore represents the store
@@ -36,3 +90,10 @@ type SQLStore struct {
 func NewStore(db *gorm.DB) Store {
 	return &SQLStore{db: db}
 }
+
+// ExecTx executes fn within a database transaction, fn receives a store bound to the transaction
+func (store *SQLStore) ExecTx(ctx context.Context, fn func(Store) error) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&SQLStore{db: tx})
+	})
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+type TagEntity struct {
+	ID        uint       `gorm:"primarykey"`
+	Name      string     `gorm:"size:32;uniqueIndex:idx_tag_user_name"`
+	Color     string     `gorm:"size:7"`
+	UserID    uint       `gorm:"uniqueIndex:idx_tag_user_name"`
+	User      UserEntity `gorm:"foreignKey:UserID"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type CreateTagArg struct {
+	Name   string
+	Color  string
+	UserID uint
+}
+
+type UpdateTagArg struct {
+	ID    uint
+	Name  *string
+	Color *string
+}
+
+func (arg *UpdateTagArg) updates() map[string]interface{} {
+	updates := map[string]interface{}{}
+	if arg.Name != nil {
+		updates["name"] = *arg.Name
+	}
+	if arg.Color != nil {
+		updates["color"] = *arg.Color
+	}
+	return updates
+}
+
+func (store *SQLStore) CreateTag(ctx context.Context, arg CreateTagArg) (model.Tag, error) {
+	tagEntity := TagEntity{
+		Name:   arg.Name,
+		Color:  arg.Color,
+		UserID: arg.UserID,
+	}
+	err := store.db.Create(&tagEntity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return tagEntity.toEmpty(), ErrDuplicatedKey
+		}
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
+			return tagEntity.toEmpty(), ErrForeignKeyViolated
+		}
+		return tagEntity.toEmpty(), ErrUnhandled
+	}
+	return tagEntity.toTag(), nil
+}
+
+func (store *SQLStore) GetTagByID(ctx context.Context, id uint) (model.Tag, error) {
+	var tagEntity TagEntity
+	err := store.db.First(&tagEntity, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tagEntity.toEmpty(), ErrRecordNotFound
+		}
+		return tagEntity.toEmpty(), ErrUnhandled
+	}
+	return tagEntity.toTag(), nil
+}
+
+func (store *SQLStore) ListTagsByUserID(ctx context.Context, userID uint) ([]model.Tag, error) {
+	var tagEntities []TagEntity
+	err := store.db.Where("user_id = ?", userID).Order("name").Find(&tagEntities).Error
+	if err != nil {
+		return nil, ErrUnhandled
+	}
+	tags := make([]model.Tag, 0, len(tagEntities))
+	for _, tagEntity := range tagEntities {
+		tags = append(tags, tagEntity.toTag())
+	}
+	return tags, nil
+}
+
+func (store *SQLStore) UpdateTagByID(ctx context.Context, arg UpdateTagArg) (model.Tag, error) {
+	tagEntity := TagEntity{ID: arg.ID}
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		updates := arg.updates()
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := tx.Model(&tagEntity).Updates(updates).Error; err != nil {
+			return err
+		}
+		if arg.Name == nil {
+			return nil
+		}
+		// plans show the names of their tags, the color is not part of them
+		return touchPlans(tx, "id IN (SELECT plan_id FROM plan_tags WHERE tag_id = ?)", arg.ID)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return tagEntity.toEmpty(), ErrDuplicatedKey
+		}
+		return tagEntity.toEmpty(), ErrUnhandled
+	}
+	err = store.db.First(&tagEntity, arg.ID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tagEntity.toEmpty(), ErrRecordNotFound
+		}
+		return tagEntity.toEmpty(), ErrUnhandled
+	}
+	return tagEntity.toTag(), nil
+}
+
+// DeleteTagByID deletes a tag, the plans it was attached to lose it
+func (store *SQLStore) DeleteTagByID(ctx context.Context, id uint) error {
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		if err := touchPlans(tx, "id IN (SELECT plan_id FROM plan_tags WHERE tag_id = ?)", id); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM plan_tags WHERE tag_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&TagEntity{}, id).Error
+	})
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// setPlanTags replaces the tags of a plan, tags are looked up by name and created when missing
+func setPlanTags(tx *gorm.DB, planEntity *PlanEntity, names []string) error {
+	tagEntities := make([]TagEntity, 0, len(names))
+	for _, name := range names {
+		tagEntity := TagEntity{Name: name, UserID: planEntity.UserID}
+		err := tx.Where(&tagEntity).FirstOrCreate(&tagEntity).Error
+		if err != nil {
+			return err
+		}
+		tagEntities = append(tagEntities, tagEntity)
+	}
+	return tx.Model(planEntity).Association("Tags").Replace(tagEntities)
+}
+
+// taggedPlanIDs selects the ids of the user's plans tagged with any, or with all, of names
+func taggedPlanIDs(db *gorm.DB, userID uint, names []string, matchAll bool) *gorm.DB {
+	query := db.Table("plan_tags").
+		Select("plan_tags.plan_id").
+		Joins("JOIN tag_entities ON tag_entities.id = plan_tags.tag_id").
+		Where("tag_entities.user_id = ? AND tag_entities.name IN ?", userID, names).
+		Group("plan_tags.plan_id")
+	if matchAll {
+		query = query.Having("COUNT(DISTINCT tag_entities.name) = ?", len(names))
+	}
+	return query
+}
+
+func (e *TagEntity) toTag() model.Tag {
+	return model.Tag{
+		ID:        e.ID,
+		Name:      e.Name,
+		Color:     e.Color,
+		UserID:    e.UserID,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}
+}
+
+func (e *TagEntity) toEmpty() model.Tag {
+	return model.Tag{}
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RecoveryCodeEntity is a single use code that stands in for a TOTP code, only its hash is stored
+type RecoveryCodeEntity struct {
+	ID        uint       `gorm:"primarykey"`
+	UserID    uint       `gorm:"index"`
+	User      UserEntity `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	CodeHash  string     `gorm:"size:64"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// SetUserTotpSecret stores the secret of a pending enrollment, it takes effect once enabled
+func (store *SQLStore) SetUserTotpSecret(ctx context.Context, id uint, secret string) error {
+	result := store.db.Model(&UserEntity{}).
+		Where("id = ? AND is_totp_enabled = ?", id, false).
+		Update("totp_secret", secret)
+	if result.Error != nil {
+		return ErrUnhandled
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+type EnableUserTotpArg struct {
+	UserID uint
+	// Counter is the time step of the code that confirmed the enrollment
+	Counter            int64
+	RecoveryCodeHashes []string
+}
+
+// EnableUserTotp turns on two-factor authentication and replaces the recovery codes of the user
+func (store *SQLStore) EnableUserTotp(ctx context.Context, arg EnableUserTotpArg) error {
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&UserEntity{}).
+			Where("id = ? AND is_totp_enabled = ? AND totp_secret <> ?", arg.UserID, false, "").
+			Updates(map[string]interface{}{"is_totp_enabled": true, "totp_last_counter": arg.Counter})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Where("user_id = ?", arg.UserID).Delete(&RecoveryCodeEntity{}).Error; err != nil {
+			return err
+		}
+		codes := make([]RecoveryCodeEntity, 0, len(arg.RecoveryCodeHashes))
+		for _, hash := range arg.RecoveryCodeHashes {
+			codes = append(codes, RecoveryCodeEntity{UserID: arg.UserID, CodeHash: hash})
+		}
+		return tx.Omit("User").Create(&codes).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRecordNotFound
+		}
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// DisableUserTotp turns off two-factor authentication and forgets the secret and recovery codes
+func (store *SQLStore) DisableUserTotp(ctx context.Context, id uint) error {
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserEntity{}).
+			Where("id = ?", id).
+			Updates(map[string]interface{}{"is_totp_enabled": false, "totp_secret": "", "totp_last_counter": 0}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&RecoveryCodeEntity{}).Error
+	})
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// UseTotpCounter records the time step of an accepted code. A code of the same or an earlier
+// step is reported as ErrAlreadyUsed so that an observed code cannot be replayed.
+func (store *SQLStore) UseTotpCounter(ctx context.Context, id uint, counter int64) error {
+	result := store.db.Model(&UserEntity{}).
+		Where("id = ? AND totp_last_counter < ?", id, counter).
+		Update("totp_last_counter", counter)
+	if result.Error != nil {
+		return ErrUnhandled
+	}
+	if result.RowsAffected == 0 {
+		return ErrAlreadyUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used, unknown and used codes are
+// reported as ErrRecordNotFound
+func (store *SQLStore) UseRecoveryCode(ctx context.Context, userID uint, codeHash string) error {
+	result := store.db.Model(&RecoveryCodeEntity{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return ErrUnhandled
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
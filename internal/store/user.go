@@ -9,11 +9,16 @@
 
 type UserEntity struct {
 	gorm.Model
-	Username       string `gorm:"unique"`
-	Email          string
-	FirstName      string
-	LastName       string
-	HashedPassword string
+	Username        string `gorm:"unique"`
+	Email           string `gorm:"uniqueIndex;size:191"`
+	FirstName       string
+	LastName        string
+	HashedPassword  string
+	IsEmailVerified bool `gorm:"not null;default:false"`
+	// TotpSecret is set on enrollment and only used for login once IsTotpEnabled
+	TotpSecret      string `gorm:"size:64"`
+	IsTotpEnabled   bool   `gorm:"not null;default:false"`
+	TotpLastCounter int64  `gorm:"not null;default:0"`
 }
 
 type CreateUserArg struct {
@@ -34,10 +39,13 @@ func (store *SQLStore) CreateUser(ctx context.Context, arg CreateUserArg) (model
 	}
 	err := store.db.Create(&userEntity).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return userEntity.toEmpty(), ErrDuplicatedKey
+		}
 		if errors.Is(err, gorm.ErrForeignKeyViolated) {
-			return userEntity.toEmpty(), errors.New(ErrForeignKeyViolated)
+			return userEntity.toEmpty(), ErrForeignKeyViolated
 		}
-		return userEntity.toEmpty(), errors.New(ErrUnhandled)
+		return userEntity.toEmpty(), ErrUnhandled
 	}
 	return userEntity.toUser(), nil
 }
@@ -47,9 +55,9 @@ func (store *SQLStore) GetUserById(ctx context.Context, id uint) (model.User, er
 	err := store.db.First(&userEntity, id).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return userEntity.toEmpty(), errors.New(ErrRecordNotFound)
+			return userEntity.toEmpty(), ErrRecordNotFound
 		}
-		return userEntity.toEmpty(), errors.New(ErrUnhandled)
+		return userEntity.toEmpty(), ErrUnhandled
 	}
 	return userEntity.toUser(), nil
 }
@@ -59,9 +67,9 @@ func (store *SQLStore) GetUserByUsername(ctx context.Context, username string) (
 	err := store.db.First(&userEntity, "username = ?", username).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return userEntity.toEmpty(), errors.New(ErrRecordNotFound)
+			return userEntity.toEmpty(), ErrRecordNotFound
 		}
-		return userEntity.toEmpty(), errors.New(ErrUnhandled)
+		return userEntity.toEmpty(), ErrUnhandled
 	}
 	return userEntity.toUser(), nil
 }
@@ -71,23 +79,132 @@ func (store *SQLStore) GetUserByEmail(ctx context.Context, email string) (model.
 	err := store.db.First(&userEntity, "email = ?", email).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return userEntity.toEmpty(), errors.New(ErrRecordNotFound)
+			return userEntity.toEmpty(), ErrRecordNotFound
 		}
-		return userEntity.toEmpty(), errors.New(ErrUnhandled)
+		return userEntity.toEmpty(), ErrUnhandled
 	}
 	return userEntity.toUser(), nil
 }
 
+// UpdateUserArg holds the profile fields to change, nil fields are left as they are
+type UpdateUserArg struct {
+	ID        uint
+	Username  *string
+	Email     *string
+	FirstName *string
+	LastName  *string
+}
+
+// UpdateUser changes the profile of a user. Taken usernames and emails are reported as
+// ErrDuplicatedKey, a changed email has to be verified again.
+func (store *SQLStore) UpdateUser(ctx context.Context, arg UpdateUserArg) (model.User, error) {
+	var userEntity UserEntity
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&userEntity, arg.ID).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if arg.Username != nil && *arg.Username != userEntity.Username {
+			updates["username"] = *arg.Username
+		}
+		if arg.Email != nil && *arg.Email != userEntity.Email {
+			updates["email"] = *arg.Email
+			updates["is_email_verified"] = false
+		}
+		if arg.FirstName != nil {
+			updates["first_name"] = *arg.FirstName
+		}
+		if arg.LastName != nil {
+			updates["last_name"] = *arg.LastName
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := tx.Model(&userEntity).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.First(&userEntity, arg.ID).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return userEntity.toEmpty(), ErrRecordNotFound
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return userEntity.toEmpty(), ErrDuplicatedKey
+		}
+		return userEntity.toEmpty(), ErrUnhandled
+	}
+	return userEntity.toUser(), nil
+}
+
+// DeleteUser removes a user for good together with its sessions, plans, tags and calendar
+// feed. Plan items, refresh tokens, one time tokens, recovery codes, personal access tokens and
+// linked identities follow through their cascades.
+func (store *SQLStore) DeleteUser(ctx context.Context, id uint) error {
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		plans := tx.Model(&PlanEntity{}).Select("id").Where("user_id = ?", id)
+		if err := tx.Exec("DELETE FROM plan_tags WHERE plan_id IN (?)", plans).Error; err != nil {
+			return err
+		}
+		for _, entity := range []interface{}{&PlanEntity{}, &TagEntity{}, &CalendarFeedEntity{}, &SessionEntity{}} {
+			if err := tx.Where("user_id = ?", id).Delete(entity).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Unscoped().Delete(&UserEntity{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRecordNotFound
+		}
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// MarkUserEmailVerified records that a user owns its email address
+func (store *SQLStore) MarkUserEmailVerified(ctx context.Context, id uint) error {
+	err := store.db.Model(&UserEntity{}).Where("id = ?", id).Update("is_email_verified", true).Error
+	if err != nil {
+		return ErrUnhandled
+	}
+	return nil
+}
+
+// UpdateUserPassword replaces the password hash of a user
+func (store *SQLStore) UpdateUserPassword(ctx context.Context, id uint, hashedPassword string) error {
+	result := store.db.Model(&UserEntity{}).Where("id = ?", id).Update("hashed_password", hashedPassword)
+	if result.Error != nil {
+		return ErrUnhandled
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
 func (u *UserEntity) toUser() model.User {
 	return model.User{
-		ID:        u.ID,
-		Username:  u.Username,
-		Email:     u.Email,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Password:  u.HashedPassword,
-		UpdatedAt: u.UpdatedAt,
-		CreatedAt: u.CreatedAt,
+		ID:              u.ID,
+		Username:        u.Username,
+		Email:           u.Email,
+		FirstName:       u.FirstName,
+		LastName:        u.LastName,
+		Password:        u.HashedPassword,
+		EmailVerified:   u.IsEmailVerified,
+		TotpEnabled:     u.IsTotpEnabled,
+		TotpSecret:      u.TotpSecret,
+		TotpLastCounter: u.TotpLastCounter,
+		UpdatedAt:       u.UpdatedAt,
+		CreatedAt:       u.CreatedAt,
 	}
 }
 
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"com.github/asdsec/planny/internal/model"
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// UserIdentityEntity links a user to the subject an OpenID Provider knows it by, a subject is
+// only unique within its provider
+type UserIdentityEntity struct {
+	ID        uint       `gorm:"primarykey"`
+	UserID    uint       `gorm:"index"`
+	User      UserEntity `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Provider  string     `gorm:"size:64;uniqueIndex:idx_user_identity_provider_subject"`
+	Subject   string     `gorm:"size:191;uniqueIndex:idx_user_identity_provider_subject"`
+	Email     string
+	CreatedAt time.Time
+}
+
+type CreateUserIdentityArg struct {
+	UserID   uint
+	Provider string
+	Subject  string
+	Email    string
+}
+
+func (store *SQLStore) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityArg) (model.UserIdentity, error) {
+	identityEntity := UserIdentityEntity{
+		UserID:   arg.UserID,
+		Provider: arg.Provider,
+		Subject:  arg.Subject,
+		Email:    arg.Email,
+	}
+	err := store.db.Omit("User").Create(&identityEntity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return identityEntity.toEmpty(), ErrDuplicatedKey
+		}
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
+			return identityEntity.toEmpty(), ErrForeignKeyViolated
+		}
+		return identityEntity.toEmpty(), ErrUnhandled
+	}
+	return identityEntity.toUserIdentity(), nil
+}
+
+// GetUserIdentity returns the identity a provider knows by subject
+func (store *SQLStore) GetUserIdentity(ctx context.Context, provider, subject string) (model.UserIdentity, error) {
+	var identityEntity UserIdentityEntity
+	err := store.db.Where("provider = ? AND subject = ?", provider, subject).First(&identityEntity).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return identityEntity.toEmpty(), ErrRecordNotFound
+		}
+		return identityEntity.toEmpty(), ErrUnhandled
+	}
+	return identityEntity.toUserIdentity(), nil
+}
+
+func (i *UserIdentityEntity) toUserIdentity() model.UserIdentity {
+	return model.UserIdentity{
+		ID:        i.ID,
+		UserID:    i.UserID,
+		Provider:  i.Provider,
+		Subject:   i.Subject,
+		Email:     i.Email,
+		CreatedAt: i.CreatedAt,
+	}
+}
+
+func (i *UserIdentityEntity) toEmpty() model.UserIdentity {
+	return model.UserIdentity{}
+}
@@ -0,0 +1,142 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the test vectors in RFC 6238 Appendix B
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// the RFC lists 8 digit codes, ours are their last Digits digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.code[len(tt.code)-Digits:]; code != want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowerCaseSecret(t *testing.T) {
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower {
+		t.Errorf("code of lower case secret = %s, want %s", lower, upper)
+	}
+}
+
+func TestCodeRejectsInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("code of an invalid secret succeeded")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Counter(now)
+	tests := []struct {
+		name    string
+		counter int64
+		ok      bool
+	}{
+		{"current step", current, true},
+		{"previous step", current - Skew, true},
+		{"next step", current + Skew, true},
+		{"too old", current - Skew - 1, false},
+		{"too new", current + Skew + 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, tt.counter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counter, ok := Validate(rfcSecret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("validate = %v, want %v", ok, tt.ok)
+			}
+			if ok && counter != tt.counter {
+				t.Errorf("validate matched step %d, want %d", counter, tt.counter)
+			}
+		})
+	}
+}
+
+func TestValidateStepBoundaries(t *testing.T) {
+	// a code of a step is accepted from the first second of the step before it until the last
+	// second of the step after it
+	step := int64(Period / time.Second)
+	counter := Counter(time.Unix(1234567890, 0))
+	code, err := Code(rfcSecret, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := (counter - Skew) * step
+	last := (counter+Skew+1)*step - 1
+	tests := []struct {
+		unix int64
+		ok   bool
+	}{
+		{first - 1, false},
+		{first, true},
+		{last, true},
+		{last + 1, false},
+	}
+	for _, tt := range tests {
+		if _, ok := Validate(rfcSecret, code, time.Unix(tt.unix, 0)); ok != tt.ok {
+			t.Errorf("validate at %d = %v, want %v", tt.unix, ok, tt.ok)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Counter(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, malformed := range []string{"", code[:Digits-1], code + "0", "0" + code} {
+		if _, ok := Validate(rfcSecret, malformed, now); ok {
+			t.Errorf("validate accepted %q", malformed)
+		}
+	}
+	if _, ok := Validate("not base32!", code, now); ok {
+		t.Error("validate accepted a code for an invalid secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != secretSize {
+		t.Errorf("secret has %d bytes, want %d", len(key), secretSize)
+	}
+}
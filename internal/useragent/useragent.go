@@ -0,0 +1,106 @@
+package useragent
+
+import (
+	"strings"
+)
+
+// Kinds of device a user agent runs on
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+	Tablet  = "tablet"
+	Other   = "other"
+)
+
+// Agent is the readable description of a User-Agent header
+type Agent struct {
+	Browser string
+	Version string
+	OS      string
+	Device  string
+}
+
+// browsers are checked in order since most browsers also claim to be the ones they derive from
+var browsers = []struct {
+	name  string
+	token string
+}{
+	{"Edge", "Edg/"},
+	{"Edge", "EdgiOS/"},
+	{"Opera", "OPR/"},
+	{"Samsung Internet", "SamsungBrowser/"},
+	{"Chrome", "CriOS/"},
+	{"Chrome", "Chrome/"},
+	{"Firefox", "FxiOS/"},
+	{"Firefox", "Firefox/"},
+	{"Safari", "Version/"},
+	{"curl", "curl/"},
+	{"Postman", "PostmanRuntime/"},
+	{"Go", "Go-http-client/"},
+}
+
+var systems = []struct {
+	name  string
+	token string
+}{
+	{"Windows", "Windows"},
+	{"iOS", "iPhone"},
+	{"iPadOS", "iPad"},
+	{"Android", "Android"},
+	{"ChromeOS", "CrOS"},
+	{"macOS", "Mac OS X"},
+	{"Linux", "Linux"},
+}
+
+// Parse recognizes the common browsers and operating systems, parts it cannot recognize are
+// left empty
+func Parse(ua string) Agent {
+	var agent Agent
+	for _, browser := range browsers {
+		if i := strings.Index(ua, browser.token); i >= 0 {
+			agent.Browser = browser.name
+			agent.Version = majorVersion(ua[i+len(browser.token):])
+			break
+		}
+	}
+	for _, system := range systems {
+		if strings.Contains(ua, system.token) {
+			agent.OS = system.name
+			break
+		}
+	}
+
+	switch {
+	case agent.OS == "iPadOS" || (agent.OS == "Android" && !strings.Contains(ua, "Mobile")):
+		agent.Device = Tablet
+	case agent.OS == "iOS" || agent.OS == "Android" || strings.Contains(ua, "Mobi"):
+		agent.Device = Mobile
+	case agent.OS != "":
+		agent.Device = Desktop
+	default:
+		agent.Device = Other
+	}
+	return agent
+}
+
+// String formats the agent as e.g. "Chrome 124 on macOS"
+func (a Agent) String() string {
+	browser := a.Browser
+	if browser == "" {
+		browser = "Unknown browser"
+	} else if a.Version != "" {
+		browser += " " + a.Version
+	}
+	if a.OS == "" {
+		return browser
+	}
+	return browser + " on " + a.OS
+}
+
+func majorVersion(s string) string {
+	end := strings.IndexAny(s, ". ;)")
+	if end < 0 {
+		end = len(s)
+	}
+	return s[:end]
+}